@@ -1,17 +1,38 @@
 package main
 
 import (
+	"flag"
 	"log"
 
+	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/profile"
+	"github.com/prabalesh/slayer/internal/scanner"
 	"github.com/prabalesh/slayer/internal/shell"
+	"github.com/prabalesh/slayer/internal/spoof"
 	"github.com/prabalesh/slayer/internal/store"
 )
 
 func main() {
-	s, err := store.NewStore()
+	var cfg store.Config
+	flag.StringVar(&cfg.Interface, "i", "", "network interface, e.g. eth0 or eth0.100 (detected when empty)")
+	flag.IntVar(&cfg.VLAN, "vlan", 0, "802.1Q VLAN ID to scan and spoof on from the trunk interface given by -i")
+	flag.StringVar(&cfg.Mode, "mode", store.ModeAuto, "auto, spoof (redirect hosts with ARP spoofing), router (this machine is their gateway), bridge (inline between -i and -wan) or local (containers and VMs on this machine)")
+	flag.StringVar(&cfg.WANInterface, "wan", "", "uplink interface in router or bridge mode (default route's interface when empty in router mode)")
+	flag.StringVar(&cfg.Bridge, "bridge", "br0", "bridge to create or join in bridge mode")
+	flag.StringVar(&cfg.Backend, "backend", limiter.BackendHTB, "traffic shaping backend (htb or edt)")
+	flag.StringVar(&cfg.ProfilesPath, "profiles", profile.DefaultPath, "JSON file holding limit profiles")
+	flag.StringVar(&cfg.HostIDsPath, "host-ids", store.DefaultIDsPath, "JSON file keeping host IDs stable across restarts (empty to not persist them)")
+	flag.IntVar(&cfg.SpoofPPS, "spoof-pps", spoof.DefaultMaxPPS, "maximum ARP frames sent per second across all spoof sessions")
+	flag.IntVar(&cfg.SpoofMaxFailures, "spoof-max-failures", spoof.DefaultMaxFailures, "consecutive failed refreshes before a spoof session is stopped (0 never stops)")
+	flag.IntVar(&cfg.Scan.PPS, "scan-pps", scanner.DefaultPPS, "ARP requests sent per second while scanning")
+	flag.IntVar(&cfg.Scan.Retries, "scan-retries", scanner.DefaultRetries, "extra scan rounds for addresses that did not answer")
+	flag.DurationVar(&cfg.Scan.Timeout, "scan-timeout", scanner.DefaultTimeout, "maximum duration of a scan")
+	flag.Parse()
+
+	s, err := store.NewStore(cfg)
 	if err != nil {
 		log.Fatal("[ERROR]: unable to initilaize store, error : ", err)
 	}
-	shellSession := shell.NewShell(*s)
+	shellSession := shell.NewShell(s)
 	shellSession.Start()
 }
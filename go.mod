@@ -4,15 +4,7 @@ go 1.24.3
 
 require (
 	github.com/chzyer/readline v1.5.1
-	github.com/mdlayher/arp v0.0.0-20220512170110-6706a2966875
+	github.com/cilium/ebpf v0.19.0
 )
 
-require (
-	github.com/josharian/native v1.0.0 // indirect
-	github.com/mdlayher/ethernet v0.0.0-20220221185849-529eae5b6118 // indirect
-	github.com/mdlayher/packet v1.0.0 // indirect
-	github.com/mdlayher/socket v0.2.1 // indirect
-	golang.org/x/net v0.0.0-20190603091049-60506f45cf65 // indirect
-	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-	golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5 // indirect
-)
+require golang.org/x/sys v0.31.0 // indirect
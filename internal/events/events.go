@@ -0,0 +1,119 @@
+// Package events carries notifications from background workers, such as
+// spoof sessions, to the shell so nothing prints over the prompt directly.
+package events
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBufferSize is how many events are queued before new ones are dropped
+const DefaultBufferSize = 256
+
+// Kind identifies what happened.
+type Kind int
+
+const (
+	// SessionStarted is published once a session's first refresh went out
+	SessionStarted Kind = iota
+	// SendFailed is published for every refresh with a failed send
+	SendFailed
+	// Recovered is published when a refresh succeeds after failures
+	Recovered
+	// SessionStopped is published once a session ended and was restored
+	SessionStopped
+	// HostMoved is published when a host shows up at a new IP or MAC
+	HostMoved
+	// GatewayChanged is published when the gateway answers from a new MAC
+	GatewayChanged
+	// WorkerError reports any other background failure
+	WorkerError
+)
+
+func (k Kind) String() string {
+	switch k {
+	case SessionStarted:
+		return "started"
+	case SendFailed:
+		return "send failed"
+	case Recovered:
+		return "recovered"
+	case SessionStopped:
+		return "stopped"
+	case HostMoved:
+		return "host moved"
+	case GatewayChanged:
+		return "gateway changed"
+	default:
+		return "error"
+	}
+}
+
+// Event is one notification.
+type Event struct {
+	Kind    Kind
+	Time    time.Time
+	Source  string // worker that published it, e.g. "spoof"
+	HostID  int64
+	IP      net.IP
+	Count   int // consecutive failures for SendFailed and Recovered
+	Message string
+	Err     error
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("[%s] %s", e.Source, e.Kind)
+	if e.IP != nil {
+		s += " " + e.IP.String()
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Err != nil {
+		s += fmt.Sprintf(" (%v)", e.Err)
+	}
+	return s
+}
+
+// Bus is a buffered channel of events. Publishing never blocks: when the
+// buffer is full the event is dropped and counted. A nil Bus discards
+// everything.
+type Bus struct {
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// NewBus returns a bus queueing up to size events.
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Bus{ch: make(chan Event, size)}
+}
+
+// Publish queues an event, stamping it with the current time if unset.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case b.ch <- e:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// Events returns the channel events are delivered on.
+func (b *Bus) Events() <-chan Event {
+	return b.ch
+}
+
+// Dropped returns how many events were lost to a full buffer.
+func (b *Bus) Dropped() uint64 {
+	return b.dropped.Load()
+}
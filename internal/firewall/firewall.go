@@ -0,0 +1,267 @@
+// Package firewall manages slayer-owned iptables and ip6tables rules outside
+// of traffic shaping.
+package firewall
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ConnLimitChain is the iptables and ip6tables chain holding all
+// connection-count rules. Keeping them in one chain lets Cleanup remove
+// everything slayer added.
+const ConnLimitChain = "SLAYER_CONNLIMIT"
+
+const conntrackPath = "/proc/net/nf_conntrack"
+
+// redirectRule drops the ICMPv6 redirects the kernel sends when forwarding a
+// packet back out the interface it came in on. There is no IPv6 sysctl
+// like IPv4's send_redirects.
+var redirectRule = []string{"OUTPUT", "-p", "ipv6-icmp", "--icmpv6-type", "redirect", "-j", "DROP"}
+
+// Firewall owns the slayer iptables chains.
+type Firewall struct {
+	mu               sync.Mutex
+	chainReady       map[string]bool // iptables or ip6tables -> chain hooked in
+	redirectsBlocked bool
+	connLimits       map[string]int      // IP -> max concurrent connections
+	connLimitIPv6    map[string][]string // IP -> IPv6 addresses capped with it
+}
+
+// NewFirewall returns a Firewall with no rules installed yet.
+func NewFirewall() *Firewall {
+	return &Firewall{
+		chainReady:    make(map[string]bool),
+		connLimits:    make(map[string]int),
+		connLimitIPv6: make(map[string][]string),
+	}
+}
+
+// runCommand executes a command and returns error if it fails
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command '%s %s' failed: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// runCommandIgnoreError executes a command but ignores errors (for cleanup operations)
+func runCommandIgnoreError(name string, args ...string) {
+	exec.Command(name, args...).Run()
+}
+
+// connLimitRule returns the rule spec capping new connections from ip at max
+func connLimitRule(ip string, max int) []string {
+	mask := "32"
+	if net.ParseIP(ip).To4() == nil {
+		mask = "128"
+	}
+	return []string{
+		"-s", ip,
+		"-m", "conntrack", "--ctstate", "NEW",
+		"-m", "connlimit", "--connlimit-above", strconv.Itoa(max), "--connlimit-mask", mask, "--connlimit-saddr",
+		"-j", "DROP",
+	}
+}
+
+// ensureChain creates the connlimit chain of tool, iptables or ip6tables,
+// and hooks it into FORWARD. Must be called with mu held.
+func (f *Firewall) ensureChain(tool string) error {
+	if f.chainReady[tool] {
+		return nil
+	}
+
+	// Start from a clean chain in case a previous run crashed
+	removeChain(tool)
+
+	if err := runCommand(tool, "-N", ConnLimitChain); err != nil {
+		return fmt.Errorf("failed to create chain %s: %v", ConnLimitChain, err)
+	}
+	if err := runCommand(tool, "-I", "FORWARD", "-j", ConnLimitChain); err != nil {
+		runCommandIgnoreError(tool, "-X", ConnLimitChain)
+		return fmt.Errorf("failed to hook chain %s into FORWARD: %v", ConnLimitChain, err)
+	}
+
+	f.chainReady[tool] = true
+	return nil
+}
+
+// removeChain unhooks and deletes the connlimit chain of tool
+func removeChain(tool string) {
+	runCommandIgnoreError(tool, "-D", "FORWARD", "-j", ConnLimitChain)
+	runCommandIgnoreError(tool, "-F", ConnLimitChain)
+	runCommandIgnoreError(tool, "-X", ConnLimitChain)
+}
+
+// BlockIPv6Redirects stops the kernel from telling redirected hosts about the
+// real IPv6 router. Calling it again is a no-op.
+func (f *Firewall) BlockIPv6Redirects() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.redirectsBlocked {
+		return nil
+	}
+	if err := runCommand("ip6tables", append([]string{"-I"}, redirectRule...)...); err != nil {
+		return fmt.Errorf("failed to block ICMPv6 redirects: %v", err)
+	}
+	f.redirectsBlocked = true
+	return nil
+}
+
+// LimitConnections caps the number of concurrent connections ip may open
+// through this machine, and each of the host's IPv6 addresses likewise.
+// Calling it again replaces the previous cap and addresses.
+func (f *Firewall) LimitConnections(ip string, ipv6 []string, max int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, addr := range append([]string{ip}, ipv6...) {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid IP address: %s", addr)
+		}
+	}
+	if max <= 0 {
+		return fmt.Errorf("invalid connection limit: %d (must be positive)", max)
+	}
+
+	if err := f.ensureChain("iptables"); err != nil {
+		return err
+	}
+	if len(ipv6) > 0 {
+		if err := f.ensureChain("ip6tables"); err != nil {
+			return err
+		}
+	}
+
+	f.removeConnLimit(ip)
+
+	if err := runCommand("iptables", append([]string{"-A", ConnLimitChain}, connLimitRule(ip, max)...)...); err != nil {
+		return fmt.Errorf("failed to add connlimit rule for %s: %v", ip, err)
+	}
+	f.connLimits[ip] = max
+	if len(ipv6) > 0 {
+		f.connLimitIPv6[ip] = ipv6
+	}
+	for _, ip6 := range ipv6 {
+		if err := runCommand("ip6tables", append([]string{"-A", ConnLimitChain}, connLimitRule(ip6, max)...)...); err != nil {
+			return fmt.Errorf("failed to add ip6tables connlimit rule for %s: %v", ip6, err)
+		}
+	}
+
+	log.Printf("Successfully limited %s to %d concurrent connections", ip, max)
+	return nil
+}
+
+// RemoveConnLimit removes the connection cap for ip and its IPv6 addresses,
+// if any.
+func (f *Firewall) RemoveConnLimit(ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.connLimits[ip]; !exists {
+		return nil
+	}
+	f.removeConnLimit(ip)
+
+	log.Printf("Successfully removed connection limit for %s", ip)
+	return nil
+}
+
+// removeConnLimit deletes the rules capping ip. Must be called with mu held.
+func (f *Firewall) removeConnLimit(ip string) {
+	max, exists := f.connLimits[ip]
+	if !exists {
+		return
+	}
+	runCommandIgnoreError("iptables", append([]string{"-D", ConnLimitChain}, connLimitRule(ip, max)...)...)
+	for _, ip6 := range f.connLimitIPv6[ip] {
+		runCommandIgnoreError("ip6tables", append([]string{"-D", ConnLimitChain}, connLimitRule(ip6, max)...)...)
+	}
+	delete(f.connLimits, ip)
+	delete(f.connLimitIPv6, ip)
+}
+
+// ConnLimit returns the connection cap configured for ip.
+func (f *Firewall) ConnLimit(ip string) (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	max, exists := f.connLimits[ip]
+	return max, exists
+}
+
+// ConnCount returns the number of tracked connections originated by ip.
+func (f *Firewall) ConnCount(ip string) (int, error) {
+	file, err := os.Open(conntrackPath)
+	if err != nil {
+		return connCountFromTool(ip)
+	}
+	defer file.Close()
+
+	needle := "src=" + ip
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The first src= field is the original direction
+		for _, field := range strings.Fields(scanner.Text()) {
+			if strings.HasPrefix(field, "src=") {
+				if field == needle {
+					count++
+				}
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", conntrackPath, err)
+	}
+	return count, nil
+}
+
+// connCountFromTool falls back to the conntrack CLI when procfs is unavailable
+func connCountFromTool(ip string) (int, error) {
+	out, err := exec.Command("conntrack", "-L", "-s", ip).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read connection table: %v", err)
+	}
+	count := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Cleanup removes every rule and chain added by slayer.
+func (f *Firewall) Cleanup() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.redirectsBlocked {
+		runCommandIgnoreError("ip6tables", append([]string{"-D"}, redirectRule...)...)
+		f.redirectsBlocked = false
+	}
+
+	if len(f.chainReady) == 0 {
+		return nil
+	}
+
+	log.Println("Cleaning up slayer firewall rules...")
+	for tool := range f.chainReady {
+		removeChain(tool)
+	}
+
+	f.connLimits = make(map[string]int)
+	f.connLimitIPv6 = make(map[string][]string)
+	f.chainReady = make(map[string]bool)
+	return nil
+}
@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// Backend names accepted by NewBackend
+const (
+	BackendHTB = "htb"
+	BackendEDT = "edt"
+)
+
+// Target identifies the host a limit applies to.
+type Target struct {
+	IP       string
+	IPv6     []string // further addresses of the host, shaped like IP
+	MAC      net.HardwareAddr
+	MatchMAC bool   // match upload on source MAC so the limit survives DHCP renewals
+	Device   string // interface only this host sits behind, shaped as a whole (DeviceLimiter)
+	UID      string // only traffic of this user, by name or number (SelfLimiter)
+	Cgroup   string // only traffic of this cgroup v2 path (SelfLimiter)
+}
+
+// Backend shapes per-host traffic on an interface. The shell only talks to
+// this interface, so every backend supports the same commands.
+type Backend interface {
+	Name() string
+	Init() error
+	Apply(t Target, spec Spec) error
+	Remove(t Target) error
+	Cleanup() error
+}
+
+// Prioritizer is implemented by backends that support QoS priority bands.
+type Prioritizer interface {
+	Prioritize(ip string, ipv6 []string, tierName string) error
+	Unprioritize(ip string) error
+	PriorityOf(ip string) (string, bool)
+	SetLinkRate(rate string) error
+	LinkRate() string
+	LinkRateSource() string
+}
+
+// NewBackend creates and initializes the named backend on iface. The eBPF
+// backend falls back to HTB when the kernel or tooling lacks support.
+func NewBackend(iface *net.Interface, name string) (Backend, error) {
+	switch name {
+	case "", BackendHTB:
+		return initHTB(iface), nil
+	case BackendEDT:
+		edt := NewEDTLimiter(iface)
+		if err := edt.Init(); err != nil {
+			log.Printf("eBPF backend unavailable (%v), falling back to HTB", err)
+			return initHTB(iface), nil
+		}
+		return edt, nil
+	default:
+		return nil, fmt.Errorf("unknown limiter backend: %s (expected %s or %s)", name, BackendHTB, BackendEDT)
+	}
+}
+
+// NewRouterBackend creates and initializes a backend for a machine the hosts'
+// traffic already crosses, as their gateway or an inline bridge. Only HTB is
+// supported: behind NAT the eBPF program cannot tell clients apart on the
+// WAN side, and on a bridge a program on the LAN port cannot pace what
+// leaves the uplink port.
+func NewRouterBackend(lan, wan *net.Interface, name string) (Backend, error) {
+	switch name {
+	case "", BackendHTB:
+	case BackendEDT:
+		log.Printf("eBPF backend cannot shape upload leaving %s, using HTB", wan.Name)
+	default:
+		return nil, fmt.Errorf("unknown limiter backend: %s (expected %s or %s)", name, BackendHTB, BackendEDT)
+	}
+	l := NewRouterLimiter(lan, wan)
+	if err := l.Init(); err != nil {
+		log.Printf("HTB init: %v", err)
+	}
+	return l, nil
+}
+
+func initHTB(iface *net.Interface) *Limiter {
+	l := NewLimiter(iface)
+	if err := l.Init(); err != nil {
+		log.Printf("HTB init: %v", err)
+	}
+	return l
+}
@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log"
+	"sync"
+)
+
+// BackendDevice is the name of the limiter shaping local workloads
+const BackendDevice = "device"
+
+// DeviceLimiter shapes local containers and VMs on the host side of their
+// veth or tap interface. The interface carries a single workload, so no
+// marks or filters are needed: download is shaped on its egress, and upload,
+// arriving as its ingress, on the egress of an IFB device it is redirected
+// to.
+type DeviceLimiter struct {
+	mu      sync.Mutex
+	devices map[string]bool // devices Apply installed qdiscs on
+}
+
+// NewDeviceLimiter returns a limiter for local workload interfaces.
+func NewDeviceLimiter() *DeviceLimiter {
+	return &DeviceLimiter{devices: make(map[string]bool)}
+}
+
+func (d *DeviceLimiter) Name() string {
+	return BackendDevice
+}
+
+// Init loads the IFB module used for upload shaping. Qdiscs are only added
+// per device by Apply.
+func (d *DeviceLimiter) Init() error {
+	runCommandIgnoreError("modprobe", "ifb", "numifbs=0")
+	return nil
+}
+
+// Apply shapes the target's device as a whole.
+func (d *DeviceLimiter) Apply(t Target, spec Spec) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t.Device == "" {
+		return fmt.Errorf("no device to shape for %s", t.IP)
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	d.devices[t.Device] = true
+	if spec.DownloadRate != "" {
+		if err := shapeEgress(t.Device, spec.DownloadRate, spec); err != nil {
+			return fmt.Errorf("failed to shape download of %s: %v", t.Device, err)
+		}
+	} else {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", t.Device, "root")
+	}
+
+	if spec.UploadRate != "" {
+		ifb, err := redirectIngress(t.Device, false)
+		if err != nil {
+			return fmt.Errorf("failed to redirect upload of %s: %v", t.Device, err)
+		}
+		if err := shapeEgress(ifb, spec.UploadRate, spec); err != nil {
+			return fmt.Errorf("failed to shape upload of %s: %v", t.Device, err)
+		}
+	} else {
+		removeIngress(t.Device)
+	}
+
+	log.Printf("Successfully applied bandwidth limits for %s on %s (%s)", t.IP, t.Device, spec)
+	return nil
+}
+
+// Remove deletes the qdiscs and IFB device of the target's device.
+func (d *DeviceLimiter) Remove(t Target) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t.Device == "" {
+		return fmt.Errorf("no device to shape for %s", t.IP)
+	}
+	runCommandIgnoreError("tc", "qdisc", "del", "dev", t.Device, "root")
+	removeIngress(t.Device)
+	delete(d.devices, t.Device)
+
+	log.Printf("Successfully removed bandwidth limits for %s on %s", t.IP, t.Device)
+	return nil
+}
+
+// Cleanup removes the shaping of every device still limited.
+func (d *DeviceLimiter) Cleanup() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log.Println("Cleaning up device limiter...")
+	for dev := range d.devices {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "root")
+		removeIngress(dev)
+	}
+	d.devices = make(map[string]bool)
+	log.Println("Cleanup completed")
+	return nil
+}
+
+// shapeEgress limits everything leaving dev to rate with a single HTB class,
+// below which the spec's leaf qdisc or impairments are attached.
+func shapeEgress(dev, rate string, spec Spec) error {
+	// An HTB root left by an earlier Apply cannot be changed, only reused
+	if err := runCommand("tc", "qdisc", "add", "dev", dev, "root", "handle", "1:", "htb", "default", "1"); err != nil {
+		runCommandIgnoreError("tc", "qdisc", "replace", "dev", dev, "root", "handle", "1:", "htb", "default", "1")
+	}
+	return setShapedClass(dev, "1:1", "10:", rate, spec)
+}
+
+// setShapedClass adds or updates an HTB class below the root of dev and
+// attaches the spec's leaf qdisc or impairments to it with leafHandle.
+func setShapedClass(dev, class, leafHandle, rate string, spec Spec) error {
+	classArgs := append([]string{"class", "replace", "dev", dev, "parent", "1:", "classid", class}, spec.htbClassArgs(rate)...)
+	if err := runCommand("tc", classArgs...); err != nil {
+		return err
+	}
+	leaf := spec.leafQdiscArgs()
+	if leaf == nil {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "parent", class)
+		return nil
+	}
+	return runCommand("tc", append([]string{"qdisc", "replace", "dev", dev, "parent", class, "handle", leafHandle}, leaf...)...)
+}
+
+// redirectIngress sends everything arriving on dev through its IFB device,
+// creating it as needed, and returns the IFB device's name. With restoreMark,
+// each packet first gets the mark saved on its connection, so filters on the
+// IFB device can classify it.
+func redirectIngress(dev string, restoreMark bool) (string, error) {
+	ifb := ifbName(dev)
+	runCommandIgnoreError("ip", "link", "add", ifb, "type", "ifb")
+	if err := runCommand("ip", "link", "set", ifb, "up"); err != nil {
+		return "", err
+	}
+	runCommandIgnoreError("tc", "qdisc", "add", "dev", dev, "handle", "ffff:", "ingress")
+	runCommandIgnoreError("tc", "filter", "del", "dev", dev, "parent", "ffff:")
+	args := []string{"filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "u32", "match", "u32", "0", "0"}
+	if restoreMark {
+		args = append(args, "action", "connmark")
+	}
+	args = append(args, "action", "mirred", "egress", "redirect", "dev", ifb)
+	if err := runCommand("tc", args...); err != nil {
+		return "", err
+	}
+	return ifb, nil
+}
+
+// removeIngress undoes redirectIngress
+func removeIngress(dev string) {
+	runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "ingress")
+	runCommandIgnoreError("ip", "link", "del", ifbName(dev))
+}
+
+// ifbName derives the IFB device used for dev's ingress, within the
+// kernel's 15 character limit
+func ifbName(dev string) string {
+	if name := "ifb-" + dev; len(name) <= 15 {
+		return name
+	}
+	return fmt.Sprintf("ifb-%08x", crc32.ChecksumIEEE([]byte(dev)))
+}
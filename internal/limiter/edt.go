@@ -0,0 +1,374 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// bpfFS is where programs are pinned for tc on kernels without tcx
+const bpfFS = "/sys/fs/bpf"
+
+// EDTLimiter shapes traffic with an eBPF program that stamps packets with an
+// earliest departure time and an fq root qdisc that enforces it. Per-host
+// state lives in BPF maps, so no classes or firewall rules are needed. The
+// programs and maps are loaded and managed from Go, see edt_program.go.
+type EDTLimiter struct {
+	iface *net.Interface
+
+	mu      sync.Mutex
+	hosts   *ebpf.Map // address -> index into rates
+	rates   *ebpf.Map // edtState per limited host and direction
+	egress  *ebpf.Program
+	ingress *ebpf.Program
+	links   []link.Link // tcx attachments
+	pinned  []string    // programs pinned for tc filters when tcx is missing
+	clsact  bool        // whether a clsact qdisc was added for the filters
+
+	targets map[string]edtHost // by Target.IP
+	free    []uint32           // indexes into rates no host uses
+	next    uint32             // lowest index never used
+}
+
+// edtHost is what a limited host occupies in the maps
+type edtHost struct {
+	keys  [][]byte
+	slots []uint32
+}
+
+// NewEDTLimiter returns an eBPF limiter for iface.
+func NewEDTLimiter(iface *net.Interface) *EDTLimiter {
+	return &EDTLimiter{
+		iface:   iface,
+		targets: make(map[string]edtHost),
+	}
+}
+
+func (e *EDTLimiter) Name() string {
+	return BackendEDT
+}
+
+// CheckSupport reports why the eBPF backend cannot be used on this machine.
+func (e *EDTLimiter) CheckSupport() error {
+	major, minor, err := kernelVersion()
+	if err != nil {
+		return err
+	}
+	// fq honours skb->tstamp since 4.20
+	if major < 4 || (major == 4 && minor < 20) {
+		return fmt.Errorf("kernel %d.%d lacks EDT support (need 4.20+)", major, minor)
+	}
+	return nil
+}
+
+// Init replaces the root qdisc with fq, loads the programs and attaches them
+// on ingress and egress.
+func (e *EDTLimiter) Init() error {
+	if err := e.CheckSupport(); err != nil {
+		return err
+	}
+	// Kernels before 5.11 charge BPF memory to the locked memory limit
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("failed to lift memlock limit: %v", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	hosts, rates, err := newEDTMaps()
+	if err != nil {
+		return err
+	}
+	e.hosts, e.rates = hosts, rates
+	e.egress, e.ingress, err = newEDTPrograms(hosts, rates)
+	if err != nil {
+		e.detach()
+		return err
+	}
+
+	if err := runCommand("tc", "qdisc", "replace", "dev", e.iface.Name, "root", "fq"); err != nil {
+		e.detach()
+		return fmt.Errorf("failed to add fq root qdisc on %s: %v", e.iface.Name, err)
+	}
+	if err := e.attach(e.ingress, ebpf.AttachTCXIngress, "ingress"); err != nil {
+		e.detach()
+		return err
+	}
+	if err := e.attach(e.egress, ebpf.AttachTCXEgress, "egress"); err != nil {
+		e.detach()
+		return err
+	}
+
+	log.Printf("eBPF EDT limiter attached to %s", e.iface.Name)
+	return nil
+}
+
+// attach hooks prog on one side of the interface with tcx, or with a tc
+// filter on kernels older than 6.6. Must be called with mu held.
+func (e *EDTLimiter) attach(prog *ebpf.Program, attachType ebpf.AttachType, side string) error {
+	l, err := link.AttachTCX(link.TCXOptions{
+		Interface: e.iface.Index,
+		Program:   prog,
+		Attach:    attachType,
+	})
+	if err == nil {
+		e.links = append(e.links, l)
+		return nil
+	}
+
+	// tc loads pinned programs only
+	if !isBPFFSMounted() {
+		runCommandIgnoreError("mount", "-t", "bpf", "bpf", bpfFS)
+	}
+	path := filepath.Join(bpfFS, fmt.Sprintf("slayer_edt_%s_%s", e.iface.Name, side))
+	os.Remove(path)
+	if err := prog.Pin(path); err != nil {
+		return fmt.Errorf("failed to pin EDT program at %s: %v", path, err)
+	}
+	e.pinned = append(e.pinned, path)
+
+	if !e.clsact {
+		if err := runCommand("tc", "qdisc", "add", "dev", e.iface.Name, "clsact"); err != nil {
+			return fmt.Errorf("failed to add clsact qdisc on %s: %v", e.iface.Name, err)
+		}
+		e.clsact = true
+	}
+	if err := runCommand("tc", "filter", "add", "dev", e.iface.Name, side, "bpf", "direct-action", "object-pinned", path); err != nil {
+		return fmt.Errorf("failed to attach EDT program on %s %s: %v", e.iface.Name, side, err)
+	}
+	return nil
+}
+
+// Apply paces the target's traffic at the spec's rates. Upload and download
+// each get one rate state shared by all of the target's addresses: its MAC
+// when matched by MAC, its IPv4 and IPv6 addresses otherwise. Pacing has no
+// classes or leaf qdiscs, so only the rates of the spec are used.
+func (e *EDTLimiter) Apply(t Target, spec Spec) error {
+	ip := t.IP
+	uploadRate, downloadRate := spec.UploadRate, spec.DownloadRate
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Validate inputs
+	if err := validateIP(ip); err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	if spec.Ceil != "" || spec.Burst != "" || spec.LeafQdisc != "" || spec.HasImpairments() {
+		log.Printf("eBPF backend ignores ceil, burst, qdisc and impairments for %s", ip)
+	}
+	addrs, err := edtAddrs(t)
+	if err != nil {
+		return err
+	}
+
+	// Start over, re-applying may change the rates and the addresses
+	e.release(ip)
+
+	var host edtHost
+	for dir, rate := range map[uint8]string{edtDirUpload: uploadRate, edtDirDownload: downloadRate} {
+		if rate == "" {
+			continue
+		}
+		bits, err := rateToBits(rate)
+		if err != nil {
+			e.forget(host)
+			return err
+		}
+		slot, err := e.alloc()
+		if err != nil {
+			e.forget(host)
+			return err
+		}
+		host.slots = append(host.slots, slot)
+		if err := e.rates.Put(slot, edtState{Rate: bits / 8}); err != nil {
+			e.forget(host)
+			return fmt.Errorf("failed to update rate map for %s: %v", ip, err)
+		}
+
+		for _, addr := range addrs {
+			key := edtKey(addr.kind, dir, addr.bytes)
+			if err := e.hosts.Put(key, slot); err != nil {
+				e.forget(host)
+				return fmt.Errorf("failed to update host map for %s: %v", ip, err)
+			}
+			host.keys = append(host.keys, key)
+		}
+	}
+	e.targets[ip] = host
+
+	log.Printf("Successfully applied bandwidth limits for %s (upload: %s, download: %s)", ip, uploadRate, downloadRate)
+	return nil
+}
+
+// Remove deletes the map entries for the target.
+func (e *EDTLimiter) Remove(t Target) error {
+	ip := t.IP
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := validateIP(ip); err != nil {
+		return err
+	}
+	e.release(ip)
+
+	log.Printf("Successfully removed bandwidth limits for %s", ip)
+	return nil
+}
+
+// Cleanup detaches the programs and restores the default qdisc.
+func (e *EDTLimiter) Cleanup() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	log.Println("Cleaning up eBPF limiter...")
+	e.detach()
+	log.Println("Cleanup completed")
+	return nil
+}
+
+// detach undoes Init as far as it got. Must be called with mu held.
+func (e *EDTLimiter) detach() {
+	for _, l := range e.links {
+		l.Close()
+	}
+	if e.clsact {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", e.iface.Name, "clsact")
+	}
+	for _, path := range e.pinned {
+		os.Remove(path)
+	}
+	runCommandIgnoreError("tc", "qdisc", "del", "dev", e.iface.Name, "root")
+
+	for _, prog := range []*ebpf.Program{e.egress, e.ingress} {
+		if prog != nil {
+			prog.Close()
+		}
+	}
+	for _, m := range []*ebpf.Map{e.hosts, e.rates} {
+		if m != nil {
+			m.Close()
+		}
+	}
+	e.links, e.pinned, e.clsact = nil, nil, false
+	e.egress, e.ingress, e.hosts, e.rates = nil, nil, nil, nil
+	e.targets = make(map[string]edtHost)
+	e.free, e.next = nil, 0
+}
+
+// release removes a limited host from the maps. Must be called with mu held.
+func (e *EDTLimiter) release(ip string) {
+	host, exists := e.targets[ip]
+	if !exists {
+		return
+	}
+	delete(e.targets, ip)
+	e.forget(host)
+}
+
+// forget deletes a host's keys and frees its rate states. Must be called
+// with mu held.
+func (e *EDTLimiter) forget(host edtHost) {
+	for _, key := range host.keys {
+		if err := e.hosts.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Printf("Failed to delete host map entry: %v", err)
+		}
+	}
+	e.free = append(e.free, host.slots...)
+}
+
+// alloc returns an unused index into rates. Must be called with mu held.
+func (e *EDTLimiter) alloc() (uint32, error) {
+	if n := len(e.free); n > 0 {
+		slot := e.free[n-1]
+		e.free = e.free[:n-1]
+		return slot, nil
+	}
+	if e.next >= edtMaxEntries {
+		return 0, fmt.Errorf("eBPF backend is limited to %d rates", edtMaxEntries)
+	}
+	e.next++
+	return e.next - 1, nil
+}
+
+// edtAddr is one address a target is matched on
+type edtAddr struct {
+	kind  uint8
+	bytes []byte
+}
+
+// edtAddrs returns the addresses the target's traffic is matched on
+func edtAddrs(t Target) ([]edtAddr, error) {
+	if t.MatchMAC {
+		if len(t.MAC) != 6 {
+			return nil, fmt.Errorf("invalid MAC address for %s: %s", t.IP, t.MAC)
+		}
+		return []edtAddr{{edtKindMAC, t.MAC}}, nil
+	}
+
+	var addrs []edtAddr
+	for _, s := range append([]string{t.IP}, t.IPv6...) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			addrs = append(addrs, edtAddr{edtKindIPv4, ip4})
+		} else {
+			addrs = append(addrs, edtAddr{edtKindIPv6, ip.To16()})
+		}
+	}
+	return addrs, nil
+}
+
+// kernelVersion returns the running kernel's major and minor version
+func kernelVersion() (int, int, error) {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read kernel version: %v", err)
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected kernel version: %s", data)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected kernel version: %s", data)
+	}
+	// Minor may carry a suffix on some distros, e.g. "4.19-rc1"
+	minorStr := parts[1]
+	if i := strings.IndexFunc(minorStr, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorStr = minorStr[:i]
+	}
+	minor, err := strconv.Atoi(minorStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected kernel version: %s", data)
+	}
+	return major, minor, nil
+}
+
+func isBPFFSMounted() bool {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[2] == "bpf" && filepath.Clean(fields[1]) == bpfFS {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package limiter
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// The EDT programs are assembled here rather than compiled from C, so the
+// backend needs no toolchain at build or run time.
+//
+// hosts maps an address to the index of its rate state in rates. Egress
+// stamps each packet of a limited host with a departure time paced at that
+// state's rate, and the fq root qdisc holds it until then. Upload is matched
+// on the source address and download on the destination. Forwarded upload
+// leaves with our MAC as source, so for hosts matched by MAC the ingress
+// program looks the source MAC up on arrival and carries the index to egress
+// in the packet mark.
+
+// Kinds of address in an edtKey
+const (
+	edtKindMAC  uint8 = 1
+	edtKindIPv4 uint8 = 2
+	edtKindIPv6 uint8 = 3
+)
+
+// Directions in an edtKey
+const (
+	edtDirUpload   uint8 = 0 // match on the source address
+	edtDirDownload uint8 = 1 // match on the destination address
+)
+
+const (
+	edtKeyLen     = 24   // kind, dir, padding, 16 bytes of address
+	edtMaxEntries = 4096 // rate states, and host addresses
+	// edtMarkBase tags the mark of packets whose upload state ingress found,
+	// the low 16 bits hold the index
+	edtMarkBase = 0x5e1a0000
+
+	nsecPerSec = 1000000000
+	// Packets that would have to wait longer than this are dropped instead
+	// of queued, which keeps fq from buffering seconds of traffic
+	edtDropHorizon = 2 * nsecPerSec
+)
+
+// Offsets into struct __sk_buff
+const (
+	skbLen     = 0
+	skbMark    = 8
+	skbData    = 76
+	skbDataEnd = 80
+	skbTstamp  = 152
+)
+
+// Packet layout
+const (
+	ethDstOff     = 0
+	ethSrcOff     = 6
+	ethProtoOff   = 12
+	ethHeaderLen  = 14
+	ipv4SrcOff    = ethHeaderLen + 12
+	ipv4DstOff    = ethHeaderLen + 16
+	ipv4HeaderLen = 20
+	ipv6SrcOff    = ethHeaderLen + 8
+	ipv6DstOff    = ethHeaderLen + 24
+	ipv6HeaderLen = 40
+)
+
+// Stack layout: the key at fp-24, its address at fp-16, an index at fp-28
+const (
+	stackKey   = -24
+	stackAddr  = -16
+	stackIndex = -28
+)
+
+// Registers kept across helper calls
+const (
+	regCtx     = asm.R6
+	regData    = asm.R7
+	regDataEnd = asm.R8
+	regState   = asm.R9
+)
+
+// edtState is a value of the rates map
+type edtState struct {
+	Rate uint64 // bytes per second
+	Last uint64 // departure time of the previous packet, in ns
+}
+
+// edtKey encodes a key of the hosts map
+func edtKey(kind, dir uint8, addr []byte) []byte {
+	key := make([]byte, edtKeyLen)
+	key[0], key[1] = kind, dir
+	copy(key[8:], addr)
+	return key
+}
+
+// newEDTMaps creates the hosts and rates maps
+func newEDTMaps() (*ebpf.Map, *ebpf.Map, error) {
+	hosts, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "slayer_hosts",
+		Type:       ebpf.Hash,
+		KeySize:    edtKeyLen,
+		ValueSize:  4,
+		MaxEntries: edtMaxEntries,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create host map: %v", err)
+	}
+	rates, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "slayer_rates",
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  16,
+		MaxEntries: edtMaxEntries,
+	})
+	if err != nil {
+		hosts.Close()
+		return nil, nil, fmt.Errorf("failed to create rate map: %v", err)
+	}
+	return hosts, rates, nil
+}
+
+// newEDTPrograms loads the egress and ingress programs using the given maps
+func newEDTPrograms(hosts, rates *ebpf.Map) (*ebpf.Program, *ebpf.Program, error) {
+	egress, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "slayer_edt",
+		Type:         ebpf.SchedCLS,
+		License:      "GPL",
+		Instructions: edtEgress(hosts.FD(), rates.FD()),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load EDT program: %v", err)
+	}
+	ingress, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "slayer_edt_in",
+		Type:         ebpf.SchedCLS,
+		License:      "GPL",
+		Instructions: edtIngress(hosts.FD()),
+	})
+	if err != nil {
+		egress.Close()
+		return nil, nil, fmt.Errorf("failed to load EDT ingress program: %v", err)
+	}
+	return egress, ingress, nil
+}
+
+// edtIngress marks packets from hosts whose upload is matched by MAC with
+// the index of their rate state
+func edtIngress(hostsFD int) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(regCtx, asm.R1),
+	}
+	insns = append(insns, loadPacket(ethHeaderLen)...)
+	insns = append(insns, buildKey(edtKindMAC, edtDirUpload, ethSrcOff, 6)...)
+	insns = append(insns,
+		asm.LoadMapPtr(asm.R1, hostsFD),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackKey),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "pass"),
+		asm.LoadMem(asm.R1, asm.R0, 0, asm.Word),
+		asm.Or.Imm(asm.R1, edtMarkBase),
+		asm.StoreMem(regCtx, skbMark, asm.R1, asm.Word),
+	)
+	return append(insns, pass()...)
+}
+
+// edtEgress paces packets of limited hosts
+func edtEgress(hostsFD, ratesFD int) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(regCtx, asm.R1),
+
+		// Upload of a host matched by MAC, found on ingress
+		asm.LoadMem(asm.R1, regCtx, skbMark, asm.Word),
+		asm.Mov.Reg(asm.R2, asm.R1),
+		asm.RSh.Imm(asm.R2, 16),
+		asm.JNE.Imm(asm.R2, edtMarkBase>>16, "parse"),
+		asm.And.Imm(asm.R1, 0xffff),
+		asm.StoreMem(asm.RFP, stackIndex, asm.R1, asm.Word),
+		asm.LoadMapPtr(asm.R1, ratesFD),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackIndex),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "parse"),
+		asm.Mov.Reg(regState, asm.R0),
+		asm.Ja.Label("pace"),
+	}
+
+	insns = append(insns, labeled("parse", loadPacket(ethHeaderLen))...)
+
+	// Download to a host matched by MAC
+	insns = append(insns, buildKey(edtKindMAC, edtDirDownload, ethDstOff, 6)...)
+	insns = append(insns, lookupState(hostsFD, ratesFD, "ethertype")...)
+	insns = append(insns, labeled("ethertype", asm.Instructions{
+		asm.LoadMem(asm.R1, regData, ethProtoOff, asm.Half),
+		asm.JEq.Imm(asm.R1, int32(etherTypeImm(0x0800)), "ipv4"),
+		asm.JEq.Imm(asm.R1, int32(etherTypeImm(0x86dd)), "ipv6"),
+		asm.Ja.Label("pass"),
+	})...)
+
+	insns = append(insns, labeled("ipv4", loadPacket(ethHeaderLen+ipv4HeaderLen))...)
+	insns = append(insns, buildKey(edtKindIPv4, edtDirUpload, ipv4SrcOff, 4)...)
+	insns = append(insns, lookupState(hostsFD, ratesFD, "ipv4_down")...)
+	insns = append(insns, labeled("ipv4_down", buildKey(edtKindIPv4, edtDirDownload, ipv4DstOff, 4))...)
+	insns = append(insns, lookupState(hostsFD, ratesFD, "pass")...)
+
+	insns = append(insns, labeled("ipv6", loadPacket(ethHeaderLen+ipv6HeaderLen))...)
+	insns = append(insns, buildKey(edtKindIPv6, edtDirUpload, ipv6SrcOff, 16)...)
+	insns = append(insns, lookupState(hostsFD, ratesFD, "ipv6_down")...)
+	insns = append(insns, labeled("ipv6_down", buildKey(edtKindIPv6, edtDirDownload, ipv6DstOff, 16))...)
+	insns = append(insns, lookupState(hostsFD, ratesFD, "pass")...)
+
+	insns = append(insns, pace()...)
+	return append(insns, pass()...)
+}
+
+// loadPacket loads the packet bounds, passing packets shorter than n
+func loadPacket(n int32) asm.Instructions {
+	return asm.Instructions{
+		asm.LoadMem(regData, regCtx, skbData, asm.Word),
+		asm.LoadMem(regDataEnd, regCtx, skbDataEnd, asm.Word),
+		asm.Mov.Reg(asm.R1, regData),
+		asm.Add.Imm(asm.R1, n),
+		asm.JGT.Reg(asm.R1, regDataEnd, "pass"),
+	}
+}
+
+// buildKey writes the key for size bytes of address at off in the packet
+// to the stack
+func buildKey(kind, dir uint8, off int16, size int) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Imm(asm.R1, 0),
+		asm.StoreMem(asm.RFP, stackKey, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, stackAddr, asm.R1, asm.DWord),
+		asm.StoreMem(asm.RFP, stackAddr+8, asm.R1, asm.DWord),
+		asm.StoreImm(asm.RFP, stackKey, int64(kind), asm.Byte),
+		asm.StoreImm(asm.RFP, stackKey+1, int64(dir), asm.Byte),
+	}
+	for i := 0; i < size; {
+		width, n := asm.Word, 4
+		if size-i < 4 {
+			width, n = asm.Half, 2
+		}
+		insns = append(insns,
+			asm.LoadMem(asm.R1, regData, off+int16(i), width),
+			asm.StoreMem(asm.RFP, stackAddr+int16(i), asm.R1, width),
+		)
+		i += n
+	}
+	return insns
+}
+
+// lookupState looks the key on the stack up and jumps to pace with its rate
+// state when found, to notFound otherwise
+func lookupState(hostsFD, ratesFD int, notFound string) asm.Instructions {
+	return asm.Instructions{
+		asm.LoadMapPtr(asm.R1, hostsFD),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackKey),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, notFound),
+		asm.LoadMem(asm.R1, asm.R0, 0, asm.Word),
+		asm.StoreMem(asm.RFP, stackIndex, asm.R1, asm.Word),
+		asm.LoadMapPtr(asm.R1, ratesFD),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackIndex),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, notFound),
+		asm.Mov.Reg(regState, asm.R0),
+		asm.Ja.Label("pace"),
+	}
+}
+
+// labeled marks the first of insns as the target of jumps to label
+func labeled(label string, insns asm.Instructions) asm.Instructions {
+	insns[0] = insns[0].WithSymbol(label)
+	return insns
+}
+
+// pace delays the packet to keep the state in regState at its rate
+func pace() asm.Instructions {
+	return asm.Instructions{
+		asm.LoadMem(asm.R1, regState, 0, asm.DWord).WithSymbol("pace"),
+		asm.JEq.Imm(asm.R1, 0, "pass"),
+
+		// tstamp = max(skb->tstamp, now), kept in R7
+		asm.FnKtimeGetNs.Call(),
+		asm.Mov.Reg(regData, asm.R0),
+		asm.LoadMem(asm.R2, regCtx, skbTstamp, asm.DWord),
+		asm.JGE.Reg(asm.R2, regData, "tstamp"),
+		asm.Mov.Reg(asm.R2, regData),
+		asm.Mov.Reg(regDataEnd, asm.R2).WithSymbol("tstamp"),
+
+		// next = t_last + len * NSEC_PER_SEC / rate
+		asm.LoadMem(asm.R3, regCtx, skbLen, asm.Word),
+		asm.Mul.Imm(asm.R3, nsecPerSec),
+		asm.LoadMem(asm.R4, regState, 0, asm.DWord),
+		asm.Div.Reg(asm.R3, asm.R4),
+		asm.LoadMem(asm.R5, regState, 8, asm.DWord),
+		asm.Add.Reg(asm.R5, asm.R3),
+
+		// Under its rate the packet leaves right away
+		asm.JGT.Reg(asm.R5, regDataEnd, "delay"),
+		asm.StoreMem(regState, 8, regDataEnd, asm.DWord),
+		asm.Ja.Label("pass"),
+
+		asm.Mov.Reg(asm.R1, asm.R5).WithSymbol("delay"),
+		asm.Sub.Reg(asm.R1, regData),
+		asm.JLT.Imm(asm.R1, edtDropHorizon, "hold"),
+		asm.Mov.Imm(asm.R0, 2), // TC_ACT_SHOT
+		asm.Return(),
+
+		asm.StoreMem(regState, 8, asm.R5, asm.DWord).WithSymbol("hold"),
+		asm.StoreMem(regCtx, skbTstamp, asm.R5, asm.DWord),
+	}
+}
+
+// pass lets the packet go on
+func pass() asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("pass"), // TC_ACT_OK
+		asm.Return(),
+	}
+}
+
+// etherTypeImm returns what a half-word load of the EtherType yields on this
+// machine
+func etherTypeImm(etherType uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], etherType)
+	return binary.NativeEndian.Uint16(b[:])
+}
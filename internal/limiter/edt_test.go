@@ -0,0 +1,244 @@
+package limiter
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+var (
+	hostMAC    = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	gatewayMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	ourMAC     = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x99}
+	hostIP     = net.ParseIP("192.168.1.10")
+	hostIPv6   = net.ParseIP("2001:db8::10")
+	remoteIP   = net.ParseIP("203.0.113.5")
+	remoteIPv6 = net.ParseIP("2001:db8:ffff::5")
+)
+
+// skbContext is a struct __sk_buff as passed to and from test runs
+type skbContext [192]byte
+
+func (c *skbContext) mark() uint32 {
+	return binary.NativeEndian.Uint32(c[skbMark:])
+}
+
+func (c *skbContext) tstamp() uint64 {
+	return binary.NativeEndian.Uint64(c[skbTstamp:])
+}
+
+// newTestEDT loads the programs without attaching them, skipping the test
+// where BPF is not permitted
+func newTestEDT(t *testing.T) *EDTLimiter {
+	t.Helper()
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Skipf("cannot lift memlock limit: %v", err)
+	}
+	hosts, rates, err := newEDTMaps()
+	if err != nil {
+		if errors.Is(err, ebpf.ErrNotSupported) || errors.Is(err, os.ErrPermission) {
+			t.Skipf("BPF not available: %v", err)
+		}
+		t.Skipf("cannot create BPF maps: %v", err)
+	}
+	egress, ingress, err := newEDTPrograms(hosts, rates)
+	if err != nil {
+		hosts.Close()
+		rates.Close()
+		t.Fatalf("newEDTPrograms() = %v", err)
+	}
+
+	e := NewEDTLimiter(nil)
+	e.hosts, e.rates, e.egress, e.ingress = hosts, rates, egress, ingress
+	t.Cleanup(func() {
+		egress.Close()
+		ingress.Close()
+		hosts.Close()
+		rates.Close()
+	})
+	return e
+}
+
+// frame builds an Ethernet frame with an IPv4 or IPv6 header and some payload
+func frame(dst, src net.HardwareAddr, srcIP, dstIP net.IP) []byte {
+	f := make([]byte, 0, 128)
+	f = append(f, dst...)
+	f = append(f, src...)
+	if srcIP.To4() != nil {
+		f = append(f, 0x08, 0x00)
+		ip := make([]byte, ipv4HeaderLen)
+		ip[0] = 0x45
+		copy(ip[12:16], srcIP.To4())
+		copy(ip[16:20], dstIP.To4())
+		f = append(f, ip...)
+	} else {
+		f = append(f, 0x86, 0xdd)
+		ip := make([]byte, ipv6HeaderLen)
+		ip[0] = 0x60
+		copy(ip[8:24], srcIP.To16())
+		copy(ip[24:40], dstIP.To16())
+		f = append(f, ip...)
+	}
+	return append(f, make([]byte, 40)...)
+}
+
+// run passes one packet through prog, returning its verdict and context
+func run(t *testing.T, prog *ebpf.Program, packet []byte, mark uint32) (uint32, skbContext) {
+	t.Helper()
+	var in, out skbContext
+	binary.NativeEndian.PutUint32(in[skbMark:], mark)
+	verdict, err := prog.Run(&ebpf.RunOptions{Data: packet, Context: in[:], ContextOut: out[:]})
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	return verdict, out
+}
+
+func TestEDTPacing(t *testing.T) {
+	e := newTestEDT(t)
+
+	// forwarded upload leaves with our MAC towards the gateway, download
+	// with our MAC towards the host
+	tests := []struct {
+		name     string
+		target   Target
+		upload   []byte
+		download []byte
+	}{
+		{
+			name:     "IPv4",
+			target:   Target{IP: hostIP.String()},
+			upload:   frame(gatewayMAC, ourMAC, hostIP, remoteIP),
+			download: frame(hostMAC, ourMAC, remoteIP, hostIP),
+		},
+		{
+			name:     "IPv6",
+			target:   Target{IP: hostIP.String(), IPv6: []string{hostIPv6.String()}},
+			upload:   frame(gatewayMAC, ourMAC, hostIPv6, remoteIPv6),
+			download: frame(hostMAC, ourMAC, remoteIPv6, hostIPv6),
+		},
+		{
+			name:     "MAC download",
+			target:   Target{IP: "192.168.1.20", MAC: hostMAC, MatchMAC: true},
+			download: frame(hostMAC, ourMAC, remoteIP, hostIP),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := e.Apply(tt.target, Spec{UploadRate: "8kbit", DownloadRate: "8kbit"}); err != nil {
+				t.Fatalf("Apply() = %v", err)
+			}
+			defer e.Remove(tt.target)
+
+			for dir, packet := range map[string][]byte{"upload": tt.upload, "download": tt.download} {
+				if packet == nil {
+					continue
+				}
+				// At 1000 bytes per second the first packet leaves right away,
+				// the next ones are delayed by about 100ms each
+				if verdict, ctx := run(t, e.egress, packet, 0); verdict != 0 || ctx.tstamp() != 0 {
+					t.Errorf("%s: first packet = %d with tstamp %d, want sent right away", dir, verdict, ctx.tstamp())
+				}
+				if verdict, ctx := run(t, e.egress, packet, 0); verdict != 0 || ctx.tstamp() == 0 {
+					t.Errorf("%s: second packet = %d with tstamp %d, want delayed", dir, verdict, ctx.tstamp())
+				}
+
+				// Past the drop horizon packets are dropped
+				dropped := false
+				for i := 0; i < 40 && !dropped; i++ {
+					verdict, _ := run(t, e.egress, packet, 0)
+					dropped = verdict == 2
+				}
+				if !dropped {
+					t.Errorf("%s: no packet dropped two seconds ahead", dir)
+				}
+			}
+		})
+	}
+}
+
+func TestEDTMACUpload(t *testing.T) {
+	e := newTestEDT(t)
+	target := Target{IP: hostIP.String(), MAC: hostMAC, MatchMAC: true}
+	if err := e.Apply(target, Spec{UploadRate: "8kbit"}); err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+
+	// Ingress sees the host's own MAC and marks the packet for egress
+	arriving := frame(ourMAC, hostMAC, hostIP, remoteIP)
+	_, ctx := run(t, e.ingress, arriving, 0)
+	slot := e.targets[target.IP].slots[0]
+	if ctx.mark() != edtMarkBase|slot {
+		t.Fatalf("mark = %#x, want %#x", ctx.mark(), edtMarkBase|slot)
+	}
+	if _, other := run(t, e.ingress, frame(ourMAC, gatewayMAC, remoteIP, hostIP), 0); other.mark() != 0 {
+		t.Errorf("mark of another host's packet = %#x, want 0", other.mark())
+	}
+
+	leaving := frame(gatewayMAC, ourMAC, hostIP, remoteIP)
+	run(t, e.egress, leaving, ctx.mark())
+	if _, out := run(t, e.egress, leaving, ctx.mark()); out.tstamp() == 0 {
+		t.Errorf("marked packet not delayed")
+	}
+	// Without the mark nothing ties the packet to the host
+	for i := 0; i < 3; i++ {
+		if _, out := run(t, e.egress, leaving, 0); out.tstamp() != 0 {
+			t.Errorf("unmarked packet delayed")
+		}
+	}
+
+	if err := e.Remove(target); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, ctx := run(t, e.ingress, arriving, 0); ctx.mark() != 0 {
+		t.Errorf("mark after Remove = %#x, want 0", ctx.mark())
+	}
+}
+
+func TestEDTBookkeeping(t *testing.T) {
+	e := newTestEDT(t)
+	target := Target{IP: hostIP.String(), IPv6: []string{hostIPv6.String()}}
+	spec := Spec{UploadRate: "1mbit", DownloadRate: "2mbit"}
+
+	for i := 0; i < 3; i++ {
+		if err := e.Apply(target, spec); err != nil {
+			t.Fatalf("Apply() = %v", err)
+		}
+	}
+	host := e.targets[target.IP]
+	if len(host.keys) != 4 || len(host.slots) != 2 {
+		t.Fatalf("host has %d keys and %d rates, want 4 and 2", len(host.keys), len(host.slots))
+	}
+	if e.next != 2 {
+		t.Errorf("re-applying used %d rates, want 2", e.next)
+	}
+
+	var state edtState
+	var slot uint32
+	if err := e.hosts.Lookup(edtKey(edtKindIPv6, edtDirDownload, hostIPv6.To16()), &slot); err != nil {
+		t.Fatalf("IPv6 download key missing: %v", err)
+	}
+	if err := e.rates.Lookup(slot, &state); err != nil || state.Rate != 250000 {
+		t.Errorf("download rate = %d (%v), want 250000 bytes per second", state.Rate, err)
+	}
+
+	if err := e.Remove(target); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	var key []byte
+	if err := e.hosts.NextKey(nil, &key); !errors.Is(err, ebpf.ErrKeyNotExist) {
+		t.Errorf("host map not empty after Remove: %x", key)
+	}
+	if _, exists := e.targets[target.IP]; exists || len(e.free) != 2 {
+		t.Errorf("Remove() left %v with %d free rates", e.targets, len(e.free))
+	}
+
+	if err := e.Apply(Target{IP: hostIP.String(), MatchMAC: true}, spec); err == nil {
+		t.Errorf("Apply() matching an unknown MAC succeeded")
+	}
+}
@@ -13,20 +13,66 @@ import (
 )
 
 type Limiter struct {
-	iface *net.Interface
+	iface     *net.Interface
+	wan       *net.Interface // router mode: upload leaves here instead of iface
+	markChain string         // mangle chain where traffic is marked
+
+	linkRate     string              // link capacity for priority bands, see LinkRate
+	priorityTree bool                // whether the priority parent/default classes exist
+	priorities   map[string]string   // IP -> priority tier
+	priorityIPv6 map[string][]string // IP -> IPv6 addresses marked with it
 }
 
 func NewLimiter(iface *net.Interface) *Limiter {
-	return &Limiter{iface: iface}
+	return &Limiter{
+		iface:        iface,
+		markChain:    "PREROUTING",
+		priorities:   make(map[string]string),
+		priorityIPv6: make(map[string][]string),
+	}
+}
+
+// NewRouterLimiter returns a limiter for a machine routing between lan and
+// wan. Download is shaped leaving lan and upload leaving wan. Traffic is
+// marked in FORWARD, where NAT has restored the client's address on replies
+// but not yet rewritten it on requests. lan and wan may also be the ports of
+// a bridge, whose frames traverse FORWARD too with br_netfilter.
+func NewRouterLimiter(lan, wan *net.Interface) *Limiter {
+	l := NewLimiter(lan)
+	l.wan = wan
+	l.markChain = "FORWARD"
+	return l
+}
+
+func (l *Limiter) Name() string {
+	return BackendHTB
 }
 
 func (l *Limiter) Init() error {
-	if err := runCommand("tc", "qdisc", "add", "dev", l.iface.Name, "root", "handle", "1:", "htb", "default", "999"); err != nil {
-		return fmt.Errorf("failed to add root qdisc on %s: %v", l.iface.Name, err)
+	for _, dev := range l.devices() {
+		if err := runCommand("tc", "qdisc", "add", "dev", dev, "root", "handle", "1:", "htb", "default", "999"); err != nil {
+			return fmt.Errorf("failed to add root qdisc on %s: %v", dev, err)
+		}
 	}
 	return nil
 }
 
+// devices returns every interface the limiter shapes
+func (l *Limiter) devices() []string {
+	if l.wan != nil && l.wan.Index != l.iface.Index {
+		return []string{l.iface.Name, l.wan.Name}
+	}
+	return []string{l.iface.Name}
+}
+
+// uploadDev returns the interface traffic sent by hosts leaves on
+func (l *Limiter) uploadDev() string {
+	if l.wan != nil {
+		return l.wan.Name
+	}
+	return l.iface.Name
+}
+
 // Mutex to prevent concurrent modifications
 var mu sync.Mutex
 
@@ -79,8 +125,13 @@ func ipToClassID(ip string, direction string) int {
 	}
 	lastOctet := parts[3]
 	base := 100
-	if direction == "up" {
+	switch direction {
+	case "up":
 		base = 200
+	case "prio":
+		// Past every limit class (up to 200+255) and below the priority
+		// default class, so marks and classes never collide
+		base = 500
 	}
 	id, err := strconv.Atoi(lastOctet)
 	if err != nil {
@@ -89,8 +140,41 @@ func ipToClassID(ip string, direction string) int {
 	return base + id
 }
 
-// Apply bandwidth limits to an IP address
-func (l *Limiter) Apply(ip, uploadRate, downloadRate string) error {
+// uploadMatch returns the iptables match selecting traffic sent by t
+func uploadMatch(t Target) []string {
+	if t.MatchMAC && len(t.MAC) > 0 {
+		return []string{"-m", "mac", "--mac-source", t.MAC.String()}
+	}
+	return []string{"-s", t.IP}
+}
+
+// uploadMatches6 returns the ip6tables matches selecting IPv6 traffic sent
+// by t, one rule each
+func uploadMatches6(t Target) [][]string {
+	if len(t.IPv6) == 0 {
+		return nil
+	}
+	if t.MatchMAC && len(t.MAC) > 0 {
+		return [][]string{{"-m", "mac", "--mac-source", t.MAC.String()}}
+	}
+	matches := make([][]string, 0, len(t.IPv6))
+	for _, ip := range t.IPv6 {
+		matches = append(matches, []string{"-s", ip})
+	}
+	return matches
+}
+
+// markRule builds an iptables mangle rule in the limiter's chain for the
+// given action
+func (l *Limiter) markRule(action string, match []string, mark string) []string {
+	args := append([]string{"-t", "mangle", action, l.markChain}, match...)
+	return append(args, "-j", "MARK", "--set-mark", mark)
+}
+
+// Apply bandwidth limits to a target host
+func (l *Limiter) Apply(t Target, spec Spec) error {
+	ip := t.IP
+	uploadRate, downloadRate := spec.UploadRate, spec.DownloadRate
 	if l.iface == nil {
 		fmt.Println("iface pointer in limiter is empty")
 		os.Exit(1)
@@ -102,10 +186,12 @@ func (l *Limiter) Apply(ip, uploadRate, downloadRate string) error {
 	if err := validateIP(ip); err != nil {
 		return err
 	}
-	if err := validateRate(uploadRate); err != nil {
-		return err
+	for _, ip6 := range t.IPv6 {
+		if err := validateIP(ip6); err != nil {
+			return err
+		}
 	}
-	if err := validateRate(downloadRate); err != nil {
+	if err := spec.Validate(); err != nil {
 		return err
 	}
 
@@ -116,18 +202,30 @@ func (l *Limiter) Apply(ip, uploadRate, downloadRate string) error {
 	// Set iptables mangle rules for upload only (download doesn't work with marks on ifb0)
 	if uploadRate != "" {
 		// Remove existing rule first (ignore errors)
-		runCommandIgnoreError("iptables", "-t", "mangle", "-D", "PREROUTING", "-s", ip, "-j", "MARK", "--set-mark", UploadMark)
-		if err := runCommand("iptables", "-t", "mangle", "-A", "PREROUTING", "-s", ip, "-j", "MARK", "--set-mark", UploadMark); err != nil {
+		runCommandIgnoreError("iptables", l.markRule("-D", uploadMatch(t), UploadMark)...)
+		if err := runCommand("iptables", l.markRule("-A", uploadMatch(t), UploadMark)...); err != nil {
 			return fmt.Errorf("failed to add iptables upload rule for %s: %v", ip, err)
 		}
+		for _, match := range uploadMatches6(t) {
+			runCommandIgnoreError("ip6tables", l.markRule("-D", match, UploadMark)...)
+			if err := runCommand("ip6tables", l.markRule("-A", match, UploadMark)...); err != nil {
+				return fmt.Errorf("failed to add ip6tables upload rule for %s: %v", ip, err)
+			}
+		}
 	}
 
 	if downloadRate != "" {
 		// Remove existing rule first (ignore errors)
-		runCommandIgnoreError("iptables", "-t", "mangle", "-D", "PREROUTING", "-s", ip, "-j", "MARK", "--set-mark", DownloadMark)
-		if err := runCommand("iptables", "-t", "mangle", "-A", "PREROUTING", "-d", ip, "-j", "MARK", "--set-mark", DownloadMark); err != nil {
+		runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-s", ip, "-j", "MARK", "--set-mark", DownloadMark)
+		if err := runCommand("iptables", "-t", "mangle", "-A", l.markChain, "-d", ip, "-j", "MARK", "--set-mark", DownloadMark); err != nil {
 			return fmt.Errorf("failed to add iptables download rule for %s: %v", ip, err)
 		}
+		for _, ip6 := range t.IPv6 {
+			runCommandIgnoreError("ip6tables", l.markRule("-D", []string{"-d", ip6}, DownloadMark)...)
+			if err := runCommand("ip6tables", l.markRule("-A", []string{"-d", ip6}, DownloadMark)...); err != nil {
+				return fmt.Errorf("failed to add ip6tables download rule for %s: %v", ip6, err)
+			}
+		}
 	}
 
 	if downloadRate != "" {
@@ -135,40 +233,76 @@ func (l *Limiter) Apply(ip, uploadRate, downloadRate string) error {
 		// runCommandIgnoreError("tc", "filter", "del", "dev", iface, "protocol", "ip", "handle", DownloadMark, "fw", "flowid", downloadClass)
 		// runCommandIgnoreError("tc", "class", "del", "dev", iface, "classid", downloadClass)
 
-		if err := runCommand("tc", "class", "add", "dev", l.iface.Name, "parent", "1:", "classid", downloadClass, "htb", "rate", downloadRate); err != nil {
-			if err := runCommand("tc", "class", "change", "dev", l.iface.Name, "parent", "1:", "classid", downloadClass, "htb", "rate", downloadRate); err != nil {
+		classArgs := append([]string{"class", "add", "dev", l.iface.Name, "parent", "1:", "classid", downloadClass}, spec.htbClassArgs(downloadRate)...)
+		if err := runCommand("tc", classArgs...); err != nil {
+			classArgs[1] = "change"
+			if err := runCommand("tc", classArgs...); err != nil {
 				return fmt.Errorf("failed to add download class for %s: %v", ip, err)
 			}
 		}
 
+		if err := l.setLeafQdisc(l.iface.Name, downloadClass, ipToClassID(ip, "down"), spec); err != nil {
+			return fmt.Errorf("failed to set download leaf qdisc for %s: %v", ip, err)
+		}
+
 		if err := runCommand("tc", "filter", "add", "dev", l.iface.Name, "protocol", "ip", "handle", DownloadMark, "fw", "flowid", downloadClass); err != nil {
 			return fmt.Errorf("failed to add upload filter for %s: %v", ip, err)
 		}
+		if len(t.IPv6) > 0 {
+			if err := runCommand("tc", "filter", "add", "dev", l.iface.Name, "protocol", "ipv6", "handle", DownloadMark, "fw", "flowid", downloadClass); err != nil {
+				return fmt.Errorf("failed to add IPv6 download filter for %s: %v", ip, err)
+			}
+		}
 	}
 
-	// Apply UPLOAD limits (on real interface)
+	// Apply UPLOAD limits (on real interface, or the WAN side in router mode)
 	if uploadRate != "" {
+		uploadDev := l.uploadDev()
 		// Remove existing class and filter first (ignore errors)
-		// runCommandIgnoreError("tc", "filter", "del", "dev", l.iface.Name, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass)
-		// runCommandIgnoreError("tc", "class", "del", "dev", l.iface.Name, "classid", uploadClass)
+		// runCommandIgnoreError("tc", "filter", "del", "dev", uploadDev, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass)
+		// runCommandIgnoreError("tc", "class", "del", "dev", uploadDev, "classid", uploadClass)
 
-		if err := runCommand("tc", "class", "add", "dev", l.iface.Name, "parent", "1:", "classid", uploadClass, "htb", "rate", uploadRate); err != nil {
-			if err := runCommand("tc", "class", "change", "dev", l.iface.Name, "parent", "1:", "classid", uploadClass, "htb", "rate", uploadRate); err != nil {
+		classArgs := append([]string{"class", "add", "dev", uploadDev, "parent", "1:", "classid", uploadClass}, spec.htbClassArgs(uploadRate)...)
+		if err := runCommand("tc", classArgs...); err != nil {
+			classArgs[1] = "change"
+			if err := runCommand("tc", classArgs...); err != nil {
 				return fmt.Errorf("failed to add upload class for %s: %v", ip, err)
 			}
 		}
 
-		if err := runCommand("tc", "filter", "add", "dev", l.iface.Name, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass); err != nil {
+		if err := l.setLeafQdisc(uploadDev, uploadClass, ipToClassID(ip, "up"), spec); err != nil {
+			return fmt.Errorf("failed to set upload leaf qdisc for %s: %v", ip, err)
+		}
+
+		if err := runCommand("tc", "filter", "add", "dev", uploadDev, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass); err != nil {
 			return fmt.Errorf("failed to add upload filter for %s: %v", ip, err)
 		}
+		if len(t.IPv6) > 0 {
+			if err := runCommand("tc", "filter", "add", "dev", uploadDev, "protocol", "ipv6", "handle", UploadMark, "fw", "flowid", uploadClass); err != nil {
+				return fmt.Errorf("failed to add IPv6 upload filter for %s: %v", ip, err)
+			}
+		}
 	}
 
-	log.Printf("Successfully applied bandwidth limits for %s (upload: %s, download: %s)", ip, uploadRate, downloadRate)
+	log.Printf("Successfully applied bandwidth limits for %s (%s)", ip, spec)
 	return nil
 }
 
-// Remove bandwidth limits from an IP address
-func (l *Limiter) Remove(ip string) error {
+// setLeafQdisc attaches the spec's leaf qdisc (or netem impairments) below a
+// class on dev, or removes a previous one when the spec has none.
+func (l *Limiter) setLeafQdisc(dev, class string, classNum int, spec Spec) error {
+	leaf := spec.leafQdiscArgs()
+	if leaf == nil {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "parent", class)
+		return nil
+	}
+	args := append([]string{"qdisc", "replace", "dev", dev, "parent", class, "handle", fmt.Sprintf("%d:", classNum)}, leaf...)
+	return runCommand("tc", args...)
+}
+
+// Remove bandwidth limits from a target host
+func (l *Limiter) Remove(t Target) error {
+	ip := t.IP
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -182,16 +316,27 @@ func (l *Limiter) Remove(ip string) error {
 	uploadClass := fmt.Sprintf("1:%d", ipToClassID(ip, "up"))
 
 	// Remove iptables mangle rules (only upload uses marks)
-	runCommandIgnoreError("iptables", "-t", "mangle", "-D", "PREROUTING", "-s", ip, "-j", "MARK", "--set-mark", UploadMark)
-	runCommandIgnoreError("iptables", "-t", "mangle", "-D", "PREROUTING", "-d", ip, "-j", "MARK", "--set-mark", DownloadMark)
+	runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-s", ip, "-j", "MARK", "--set-mark", UploadMark)
+	runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-d", ip, "-j", "MARK", "--set-mark", DownloadMark)
+	if len(t.MAC) > 0 {
+		runCommandIgnoreError("iptables", l.markRule("-D", []string{"-m", "mac", "--mac-source", t.MAC.String()}, UploadMark)...)
+		runCommandIgnoreError("ip6tables", l.markRule("-D", []string{"-m", "mac", "--mac-source", t.MAC.String()}, UploadMark)...)
+	}
+	for _, ip6 := range t.IPv6 {
+		runCommandIgnoreError("ip6tables", l.markRule("-D", []string{"-s", ip6}, UploadMark)...)
+		runCommandIgnoreError("ip6tables", l.markRule("-D", []string{"-d", ip6}, DownloadMark)...)
+	}
 
 	// Remove tc download filter + class (from ifb0 if download limits were applied)
 	runCommandIgnoreError("tc", "filter", "del", "dev", l.iface.Name, "protocol", "ip", "handle", DownloadMark, "fw", "flowid", downloadClass)
+	runCommandIgnoreError("tc", "filter", "del", "dev", l.iface.Name, "protocol", "ipv6", "handle", DownloadMark, "fw", "flowid", downloadClass)
 	runCommandIgnoreError("tc", "class", "del", "dev", l.iface.Name, "classid", downloadClass)
 
-	// Remove tc upload filter + class (from real interface)
-	runCommandIgnoreError("tc", "filter", "del", "dev", l.iface.Name, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass)
-	runCommandIgnoreError("tc", "class", "del", "dev", l.iface.Name, "classid", uploadClass)
+	// Remove tc upload filter + class (from real interface, or the WAN side)
+	uploadDev := l.uploadDev()
+	runCommandIgnoreError("tc", "filter", "del", "dev", uploadDev, "protocol", "ip", "handle", UploadMark, "fw", "flowid", uploadClass)
+	runCommandIgnoreError("tc", "filter", "del", "dev", uploadDev, "protocol", "ipv6", "handle", UploadMark, "fw", "flowid", uploadClass)
+	runCommandIgnoreError("tc", "class", "del", "dev", uploadDev, "classid", uploadClass)
 
 	log.Printf("Successfully removed bandwidth limits for %s", ip)
 	return nil
@@ -204,9 +349,21 @@ func (l *Limiter) Cleanup() error {
 
 	log.Println("Cleaning up all bandwidth limiting rules...")
 
+	// Remove priority marks left behind
+	for ip := range l.priorities {
+		mark := strconv.Itoa(ipToClassID(ip, "prio"))
+		runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-s", ip, "-j", "MARK", "--set-mark", mark)
+		runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-d", ip, "-j", "MARK", "--set-mark", mark)
+		l.unmarkPriorityIPv6(ip, mark, fmt.Sprintf("1:%s", mark))
+	}
+	l.priorities = make(map[string]string)
+
 	// Remove tc qdiscs
-	runCommandIgnoreError("tc", "qdisc", "del", "dev", l.iface.Name, "root")
-	runCommandIgnoreError("tc", "qdisc", "del", "dev", l.iface.Name, "ingress")
+	for _, dev := range l.devices() {
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "root")
+		runCommandIgnoreError("tc", "qdisc", "del", "dev", dev, "ingress")
+	}
+	l.priorityTree = false
 
 	log.Println("Cleanup completed")
 	return nil
@@ -0,0 +1,25 @@
+package limiter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIPToClassIDPriorityRange(t *testing.T) {
+	limits := make(map[int]string)
+	for octet := 0; octet <= 255; octet++ {
+		ip := fmt.Sprintf("192.168.1.%d", octet)
+		limits[ipToClassID(ip, "down")] = ip
+		limits[ipToClassID(ip, "up")] = ip
+	}
+	for octet := 0; octet <= 255; octet++ {
+		ip := fmt.Sprintf("192.168.1.%d", octet)
+		id := ipToClassID(ip, "prio")
+		if other, taken := limits[id]; taken {
+			t.Errorf("priority class of %s is %d, a limit class of %s", ip, id, other)
+		}
+		if fmt.Sprintf("1:%d", id) == priorityDefaultClass || fmt.Sprint(id) == DownloadMark || fmt.Sprint(id) == UploadMark {
+			t.Errorf("priority class of %s is %d, a reserved class or mark", ip, id)
+		}
+	}
+}
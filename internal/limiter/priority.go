@@ -0,0 +1,338 @@
+package limiter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Classes used by priority mode. The parent class spans the whole link so
+// that prioritized hosts and everyone else can borrow unused bandwidth from it.
+const (
+	priorityParentClass  = "1:1"
+	priorityDefaultClass = "1:999"
+	priorityDefaultPrio  = "7"
+	priorityDefaultShare = 5
+
+	// DefaultLinkRate is the link capacity assumed for priority bands when it
+	// is neither set nor reported by the interface
+	DefaultLinkRate = "100mbit"
+)
+
+// PriorityTier describes an HTB priority band and its guaranteed share of the link.
+type PriorityTier struct {
+	Name  string
+	Prio  int // HTB prio, lower wins spare bandwidth first
+	Share int // guaranteed minimum rate in percent of the link rate
+}
+
+// PriorityTiers lists the supported tiers, highest first.
+var PriorityTiers = []PriorityTier{
+	{Name: "high", Prio: 0, Share: 60},
+	{Name: "medium", Prio: 2, Share: 25},
+	{Name: "low", Prio: 5, Share: 10},
+}
+
+// LookupPriorityTier returns the tier with the given name.
+func LookupPriorityTier(name string) (PriorityTier, bool) {
+	for _, tier := range PriorityTiers {
+		if tier.Name == strings.ToLower(name) {
+			return tier, true
+		}
+	}
+	return PriorityTier{}, false
+}
+
+// SetLinkRate sets the link capacity priority bands are derived from.
+// It only takes effect the next time the priority tree is built.
+func (l *Limiter) SetLinkRate(rate string) error {
+	if err := validateRate(rate); err != nil {
+		return err
+	}
+	if _, err := rateToBits(rate); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	l.linkRate = rate
+	return nil
+}
+
+// LinkRate returns the link capacity used for priority bands: the one set
+// with SetLinkRate, else the speed the interfaces report, else
+// DefaultLinkRate.
+func (l *Limiter) LinkRate() string {
+	mu.Lock()
+	defer mu.Unlock()
+	rate, _ := l.linkRateAndSource()
+	return rate
+}
+
+// LinkRateSource tells where LinkRate comes from: "set", "detected from
+// <interface>" or "assumed".
+func (l *Limiter) LinkRateSource() string {
+	mu.Lock()
+	defer mu.Unlock()
+	_, source := l.linkRateAndSource()
+	return source
+}
+
+// linkRateAndSource implements LinkRate and LinkRateSource. Must be called
+// with mu held.
+func (l *Limiter) linkRateAndSource() (string, string) {
+	if l.linkRate != "" {
+		return l.linkRate, "set"
+	}
+	if speed, dev, ok := l.linkSpeed(); ok {
+		return fmt.Sprintf("%dmbit", speed), "detected from " + dev
+	}
+	return DefaultLinkRate, "assumed"
+}
+
+// linkSpeed returns the lowest speed in Mbit/s reported by the shaped
+// interfaces and the interface reporting it. Wireless and virtual interfaces
+// report none.
+func (l *Limiter) linkSpeed() (int, string, bool) {
+	var slowest int
+	var slowestDev string
+	for _, dev := range l.devices() {
+		data, err := os.ReadFile(filepath.Join("/sys/class/net", dev, "speed"))
+		if err != nil {
+			continue
+		}
+		speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || speed <= 0 {
+			continue
+		}
+		if slowestDev == "" || speed < slowest {
+			slowest, slowestDev = speed, dev
+		}
+	}
+	return slowest, slowestDev, slowestDev != ""
+}
+
+// Prioritize places an IP address, along with the host's IPv6 addresses,
+// into the given priority tier. The host gets a guaranteed minimum rate and
+// may borrow up to the full link rate, while all unclassified traffic falls
+// into the lowest band. Calling it again replaces the tier and the IPv6
+// addresses.
+func (l *Limiter) Prioritize(ip string, ipv6 []string, tierName string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := validateIP(ip); err != nil {
+		return err
+	}
+	for _, ip6 := range ipv6 {
+		if err := validateIP(ip6); err != nil {
+			return err
+		}
+	}
+	tier, ok := LookupPriorityTier(tierName)
+	if !ok {
+		return fmt.Errorf("unknown priority tier: %s", tierName)
+	}
+
+	if err := l.ensurePriorityTree(); err != nil {
+		return err
+	}
+
+	linkRate, _ := l.linkRateAndSource()
+	linkBits, err := rateToBits(linkRate)
+	if err != nil {
+		return err
+	}
+	guaranteed := bitsToRate(linkBits * uint64(tier.Share) / 100)
+
+	classNum := ipToClassID(ip, "prio")
+	class := fmt.Sprintf("1:%d", classNum)
+	mark := strconv.Itoa(classNum)
+
+	for _, dev := range l.devices() {
+		if err := runCommand("tc", "class", "add", "dev", dev, "parent", priorityParentClass, "classid", class,
+			"htb", "rate", guaranteed, "ceil", linkRate, "prio", strconv.Itoa(tier.Prio)); err != nil {
+			if err := runCommand("tc", "class", "change", "dev", dev, "parent", priorityParentClass, "classid", class,
+				"htb", "rate", guaranteed, "ceil", linkRate, "prio", strconv.Itoa(tier.Prio)); err != nil {
+				return fmt.Errorf("failed to add priority class for %s: %v", ip, err)
+			}
+		}
+	}
+
+	if _, exists := l.priorities[ip]; !exists {
+		// Mark both directions with a per-host mark so the filter hits this class only
+		for _, match := range []string{"-s", "-d"} {
+			if err := runCommand("iptables", "-t", "mangle", "-A", l.markChain, match, ip, "-j", "MARK", "--set-mark", mark); err != nil {
+				return fmt.Errorf("failed to add iptables priority rule for %s: %v", ip, err)
+			}
+		}
+		for _, dev := range l.devices() {
+			if err := runCommand("tc", "filter", "add", "dev", dev, "protocol", "ip", "handle", mark, "fw", "flowid", class); err != nil {
+				return fmt.Errorf("failed to add priority filter for %s: %v", ip, err)
+			}
+		}
+	}
+	l.priorities[ip] = tier.Name
+
+	// IPv6 traffic of the host shares its class
+	l.unmarkPriorityIPv6(ip, mark, class)
+	if len(ipv6) > 0 {
+		l.priorityIPv6[ip] = ipv6
+		for _, ip6 := range ipv6 {
+			for _, match := range []string{"-s", "-d"} {
+				if err := runCommand("ip6tables", "-t", "mangle", "-A", l.markChain, match, ip6, "-j", "MARK", "--set-mark", mark); err != nil {
+					return fmt.Errorf("failed to add ip6tables priority rule for %s: %v", ip6, err)
+				}
+			}
+		}
+		for _, dev := range l.devices() {
+			if err := runCommand("tc", "filter", "add", "dev", dev, "protocol", "ipv6", "handle", mark, "fw", "flowid", class); err != nil {
+				return fmt.Errorf("failed to add IPv6 priority filter for %s: %v", ip, err)
+			}
+		}
+	}
+
+	log.Printf("Successfully prioritized %s (tier: %s, guaranteed: %s, ceil: %s)", ip, tier.Name, guaranteed, linkRate)
+	return nil
+}
+
+// Unprioritize removes an IP address from priority mode. The priority tree is
+// torn down once no prioritized hosts remain.
+func (l *Limiter) Unprioritize(ip string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := validateIP(ip); err != nil {
+		return err
+	}
+
+	classNum := ipToClassID(ip, "prio")
+	class := fmt.Sprintf("1:%d", classNum)
+	mark := strconv.Itoa(classNum)
+
+	runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-s", ip, "-j", "MARK", "--set-mark", mark)
+	runCommandIgnoreError("iptables", "-t", "mangle", "-D", l.markChain, "-d", ip, "-j", "MARK", "--set-mark", mark)
+	l.unmarkPriorityIPv6(ip, mark, class)
+	for _, dev := range l.devices() {
+		runCommandIgnoreError("tc", "filter", "del", "dev", dev, "protocol", "ip", "handle", mark, "fw", "flowid", class)
+		runCommandIgnoreError("tc", "class", "del", "dev", dev, "classid", class)
+	}
+
+	delete(l.priorities, ip)
+	if len(l.priorities) == 0 {
+		l.removePriorityTree()
+	}
+
+	log.Printf("Successfully removed priority for %s", ip)
+	return nil
+}
+
+// unmarkPriorityIPv6 removes the rules marking ip's IPv6 addresses for its
+// priority class. Must be called with mu held.
+func (l *Limiter) unmarkPriorityIPv6(ip, mark, class string) {
+	ipv6, exists := l.priorityIPv6[ip]
+	if !exists {
+		return
+	}
+	for _, ip6 := range ipv6 {
+		runCommandIgnoreError("ip6tables", "-t", "mangle", "-D", l.markChain, "-s", ip6, "-j", "MARK", "--set-mark", mark)
+		runCommandIgnoreError("ip6tables", "-t", "mangle", "-D", l.markChain, "-d", ip6, "-j", "MARK", "--set-mark", mark)
+	}
+	for _, dev := range l.devices() {
+		runCommandIgnoreError("tc", "filter", "del", "dev", dev, "protocol", "ipv6", "handle", mark, "fw", "flowid", class)
+	}
+	delete(l.priorityIPv6, ip)
+}
+
+// PriorityOf returns the tier an IP address is currently placed in.
+func (l *Limiter) PriorityOf(ip string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	tier, ok := l.priorities[ip]
+	return tier, ok
+}
+
+// ensurePriorityTree builds the parent and default classes under the root
+// qdisc created by Init. Must be called with mu held.
+func (l *Limiter) ensurePriorityTree() error {
+	if l.priorityTree {
+		return nil
+	}
+
+	linkRate, _ := l.linkRateAndSource()
+	linkBits, err := rateToBits(linkRate)
+	if err != nil {
+		return err
+	}
+	defaultRate := bitsToRate(linkBits * priorityDefaultShare / 100)
+
+	for _, dev := range l.devices() {
+		if err := runCommand("tc", "class", "add", "dev", dev, "parent", "1:", "classid", priorityParentClass,
+			"htb", "rate", linkRate, "ceil", linkRate); err != nil {
+			l.deletePriorityTree()
+			return fmt.Errorf("failed to add priority parent class on %s: %v", dev, err)
+		}
+
+		// Root qdisc sends unclassified traffic to 1:999, so everyone else lands here
+		if err := runCommand("tc", "class", "add", "dev", dev, "parent", priorityParentClass, "classid", priorityDefaultClass,
+			"htb", "rate", defaultRate, "ceil", linkRate, "prio", priorityDefaultPrio); err != nil {
+			l.deletePriorityTree()
+			return fmt.Errorf("failed to add priority default class on %s: %v", dev, err)
+		}
+	}
+
+	l.priorityTree = true
+	return nil
+}
+
+// removePriorityTree deletes the classes built by ensurePriorityTree. Must be
+// called with mu held.
+func (l *Limiter) removePriorityTree() {
+	if !l.priorityTree {
+		return
+	}
+	l.deletePriorityTree()
+	l.priorityTree = false
+}
+
+// deletePriorityTree deletes the priority classes from every shaped device
+func (l *Limiter) deletePriorityTree() {
+	for _, dev := range l.devices() {
+		runCommandIgnoreError("tc", "class", "del", "dev", dev, "classid", priorityDefaultClass)
+		runCommandIgnoreError("tc", "class", "del", "dev", dev, "classid", priorityParentClass)
+	}
+}
+
+var rateRegexp = regexp.MustCompile(`^(\d+)(bit|kbit|mbit|gbit|tbit|bps|kbps|mbps|gbps|tbps)$`)
+
+// rateToBits converts a tc rate string into bits per second
+func rateToBits(rate string) (uint64, error) {
+	m := rateRegexp.FindStringSubmatch(rate)
+	if m == nil {
+		return 0, fmt.Errorf("invalid rate format: %s", rate)
+	}
+	value, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate value: %s", rate)
+	}
+
+	multipliers := map[string]uint64{
+		"bit": 1, "kbit": 1000, "mbit": 1000 * 1000, "gbit": 1000 * 1000 * 1000, "tbit": 1000 * 1000 * 1000 * 1000,
+		"bps": 8, "kbps": 8 * 1000, "mbps": 8 * 1000 * 1000, "gbps": 8 * 1000 * 1000 * 1000, "tbps": 8 * 1000 * 1000 * 1000 * 1000,
+	}
+	return value * multipliers[m[2]], nil
+}
+
+// bitsToRate formats bits per second as a tc rate string
+func bitsToRate(bits uint64) string {
+	if bits < 8000 {
+		bits = 8000 // HTB refuses absurdly small rates
+	}
+	if bits%1000000 == 0 {
+		return fmt.Sprintf("%dmbit", bits/1000000)
+	}
+	return fmt.Sprintf("%dkbit", bits/1000)
+}
@@ -0,0 +1,196 @@
+package limiter
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// BackendSelf is the name of the limiter shaping this machine's own traffic
+const BackendSelf = "self"
+
+// SelfMark marks this machine's own shaped traffic, and selfClass is the
+// HTB class it is sent to on the interface and on its IFB device
+const (
+	SelfMark  = "30"
+	selfClass = "1:30"
+)
+
+var userRegexp = regexp.MustCompile(`^([0-9]+|[a-z_][a-z0-9_-]*\$?)$`)
+
+// SelfLimiter shapes this machine's own traffic on iface, optionally only
+// that of one user or cgroup. Packets sent are marked in OUTPUT and the mark
+// is saved on their connection, so replies arriving on iface get it back
+// when they are redirected to an IFB device and can be shaped there. Upload
+// is what this machine sends, download what it receives.
+type SelfLimiter struct {
+	iface *net.Interface
+
+	mu    sync.Mutex
+	match []string // OUTPUT match of the shaped traffic, nil when not applied
+}
+
+// NewSelfLimiter returns a limiter for this machine's traffic on iface.
+func NewSelfLimiter(iface *net.Interface) *SelfLimiter {
+	return &SelfLimiter{iface: iface}
+}
+
+func (l *SelfLimiter) Name() string {
+	return BackendSelf
+}
+
+// Init loads the IFB module used for download shaping. The root qdisc of
+// iface belongs to the main backend, whose Init creates it.
+func (l *SelfLimiter) Init() error {
+	runCommandIgnoreError("modprobe", "ifb", "numifbs=0")
+	return nil
+}
+
+// Interface returns the interface this machine's traffic is shaped on.
+func (l *SelfLimiter) Interface() *net.Interface {
+	return l.iface
+}
+
+// Apply shapes the traffic selected by the target's UID or Cgroup, or all of
+// this machine's traffic on the interface when neither is set.
+func (l *SelfLimiter) Apply(t Target, spec Spec) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	match, err := l.selfMatch(t)
+	if err != nil {
+		return err
+	}
+
+	// Replace the marks, the user or cgroup may have changed
+	l.removeMarks()
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		for _, target := range [][]string{{"MARK", "--set-mark", SelfMark}, {"CONNMARK", "--save-mark"}} {
+			args := append(append([]string{"-t", "mangle", "-A", "OUTPUT"}, match...), append([]string{"-j"}, target...)...)
+			if tool == "ip6tables" {
+				runCommandIgnoreError(tool, args...) // IPv6 may be disabled
+				continue
+			}
+			if err := runCommand(tool, args...); err != nil {
+				l.removeMarksFor(match)
+				return fmt.Errorf("failed to add iptables rule for this machine: %v", err)
+			}
+		}
+	}
+	l.match = match
+
+	dev := l.iface.Name
+	if spec.UploadRate != "" {
+		// Shares the root created by the main backend's Init
+		runCommandIgnoreError("tc", "qdisc", "add", "dev", dev, "root", "handle", "1:", "htb", "default", "999")
+		if err := setShapedClass(dev, selfClass, SelfMark+":", spec.UploadRate, spec); err != nil {
+			return fmt.Errorf("failed to shape upload on %s (needs the %s backend): %v", dev, BackendHTB, err)
+		}
+		runCommandIgnoreError("tc", "filter", "del", "dev", dev, "protocol", "all", "handle", SelfMark, "fw", "flowid", selfClass)
+		if err := runCommand("tc", "filter", "add", "dev", dev, "protocol", "all", "handle", SelfMark, "fw", "flowid", selfClass); err != nil {
+			return fmt.Errorf("failed to add upload filter on %s: %v", dev, err)
+		}
+	} else {
+		l.removeUpload()
+	}
+
+	if spec.DownloadRate != "" {
+		ifb, err := redirectIngress(dev, true)
+		if err != nil {
+			return fmt.Errorf("failed to redirect download of %s: %v", dev, err)
+		}
+		// Without a default class, traffic of others passes the IFB unshaped
+		runCommandIgnoreError("tc", "qdisc", "add", "dev", ifb, "root", "handle", "1:", "htb")
+		if err := setShapedClass(ifb, selfClass, SelfMark+":", spec.DownloadRate, spec); err != nil {
+			return fmt.Errorf("failed to shape download of %s: %v", dev, err)
+		}
+		runCommandIgnoreError("tc", "filter", "del", "dev", ifb, "protocol", "all", "handle", SelfMark, "fw", "flowid", selfClass)
+		if err := runCommand("tc", "filter", "add", "dev", ifb, "protocol", "all", "handle", SelfMark, "fw", "flowid", selfClass); err != nil {
+			return fmt.Errorf("failed to add download filter on %s: %v", ifb, err)
+		}
+	} else {
+		removeIngress(dev)
+	}
+
+	log.Printf("Successfully applied bandwidth limits for this machine on %s (%s)", dev, spec)
+	return nil
+}
+
+// Remove deletes the marks, classes and IFB device of this machine's limit.
+func (l *SelfLimiter) Remove(t Target) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove()
+	log.Printf("Successfully removed bandwidth limits for this machine on %s", l.iface.Name)
+	return nil
+}
+
+// Cleanup removes this machine's limit if one is still applied.
+func (l *SelfLimiter) Cleanup() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.match != nil {
+		l.remove()
+	}
+	return nil
+}
+
+// remove undoes Apply. Must be called with mu held.
+func (l *SelfLimiter) remove() {
+	l.removeMarks()
+	l.removeUpload()
+	removeIngress(l.iface.Name)
+}
+
+// removeUpload deletes the upload filter and class from the interface
+func (l *SelfLimiter) removeUpload() {
+	runCommandIgnoreError("tc", "filter", "del", "dev", l.iface.Name, "protocol", "all", "handle", SelfMark, "fw", "flowid", selfClass)
+	runCommandIgnoreError("tc", "class", "del", "dev", l.iface.Name, "classid", selfClass)
+}
+
+// removeMarks deletes the OUTPUT rules added by Apply. Must be called with
+// mu held.
+func (l *SelfLimiter) removeMarks() {
+	if l.match != nil {
+		l.removeMarksFor(l.match)
+	}
+	l.match = nil
+}
+
+func (l *SelfLimiter) removeMarksFor(match []string) {
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		for _, target := range [][]string{{"MARK", "--set-mark", SelfMark}, {"CONNMARK", "--save-mark"}} {
+			args := append(append([]string{"-t", "mangle", "-D", "OUTPUT"}, match...), append([]string{"-j"}, target...)...)
+			runCommandIgnoreError(tool, args...)
+		}
+	}
+}
+
+// selfMatch returns the OUTPUT match selecting the target's traffic
+func (l *SelfLimiter) selfMatch(t Target) ([]string, error) {
+	match := []string{"-o", l.iface.Name}
+	if t.UID != "" {
+		if !userRegexp.MatchString(t.UID) {
+			return nil, fmt.Errorf("invalid user: %s", t.UID)
+		}
+		match = append(match, "-m", "owner", "--uid-owner", t.UID)
+	}
+	if t.Cgroup != "" {
+		// iptables takes cgroup v2 paths relative to the hierarchy's root
+		path := filepath.Clean("/" + t.Cgroup)
+		if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", path)); err != nil {
+			return nil, fmt.Errorf("cgroup %s not found", t.Cgroup)
+		}
+		match = append(match, "-m", "cgroup", "--path", path[1:])
+	}
+	return match, nil
+}
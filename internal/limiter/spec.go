@@ -0,0 +1,165 @@
+package limiter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Spec describes the shaping applied to a host.
+type Spec struct {
+	UploadRate   string `json:"upload,omitempty"`
+	DownloadRate string `json:"download,omitempty"`
+	Ceil         string `json:"ceil,omitempty"`  // max rate when borrowing spare bandwidth
+	Burst        string `json:"burst,omitempty"` // HTB bucket size, e.g. "32k"
+	LeafQdisc    string `json:"qdisc,omitempty"` // leaf qdisc under each class
+	Delay        string `json:"delay,omitempty"` // netem impairments
+	Jitter       string `json:"jitter,omitempty"`
+	Loss         string `json:"loss,omitempty"`
+}
+
+// LeafQdiscs lists the leaf qdiscs accepted in a Spec
+var LeafQdiscs = []string{"pfifo", "sfq", "fq_codel", "cake"}
+
+var (
+	burstRegexp = regexp.MustCompile(`^\d+(b|k|kb|m|mb|kbit|mbit)?$`)
+	timeRegexp  = regexp.MustCompile(`^\d+(\.\d+)?(us|ms|s)$`)
+	lossRegexp  = regexp.MustCompile(`^\d+(\.\d+)?%$`)
+)
+
+// HasRates reports whether the spec limits at least one direction.
+func (s Spec) HasRates() bool {
+	return s.UploadRate != "" || s.DownloadRate != ""
+}
+
+// HasImpairments reports whether the spec needs netem.
+func (s Spec) HasImpairments() bool {
+	return s.Delay != "" || s.Jitter != "" || s.Loss != ""
+}
+
+// Validate checks every field of the spec.
+func (s Spec) Validate() error {
+	if err := validateRate(s.UploadRate); err != nil {
+		return err
+	}
+	if err := validateRate(s.DownloadRate); err != nil {
+		return err
+	}
+	if err := validateRate(s.Ceil); err != nil {
+		return err
+	}
+	if s.Burst != "" && !burstRegexp.MatchString(s.Burst) {
+		return fmt.Errorf("invalid burst format: %s (expected format like '32k', '1mb')", s.Burst)
+	}
+	if s.LeafQdisc != "" && !isLeafQdisc(s.LeafQdisc) {
+		return fmt.Errorf("unsupported leaf qdisc: %s (expected one of %s)", s.LeafQdisc, strings.Join(LeafQdiscs, ", "))
+	}
+	for _, t := range []string{s.Delay, s.Jitter} {
+		if t != "" && !timeRegexp.MatchString(t) {
+			return fmt.Errorf("invalid time format: %s (expected format like '100ms')", t)
+		}
+	}
+	if s.Jitter != "" && s.Delay == "" {
+		return fmt.Errorf("jitter requires a delay")
+	}
+	if s.Loss != "" && !lossRegexp.MatchString(s.Loss) {
+		return fmt.Errorf("invalid loss format: %s (expected format like '1%%')", s.Loss)
+	}
+	return nil
+}
+
+// Set updates a field by its config key. A value of "none" clears the field.
+func (s *Spec) Set(key, value string) error {
+	if value == "none" {
+		value = ""
+	}
+	switch key {
+	case "upload", "up":
+		s.UploadRate = value
+	case "download", "down":
+		s.DownloadRate = value
+	case "ceil":
+		s.Ceil = value
+	case "burst":
+		s.Burst = value
+	case "qdisc":
+		s.LeafQdisc = value
+	case "delay":
+		s.Delay = value
+	case "jitter":
+		s.Jitter = value
+	case "loss":
+		s.Loss = value
+	default:
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+	return nil
+}
+
+// WithoutRates returns a copy of the spec with both rates cleared.
+func (s Spec) WithoutRates() Spec {
+	s.UploadRate = ""
+	s.DownloadRate = ""
+	return s
+}
+
+// String summarizes the spec for display.
+func (s Spec) String() string {
+	var parts []string
+	add := func(key, value string) {
+		if value != "" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	add("up", s.UploadRate)
+	add("down", s.DownloadRate)
+	add("ceil", s.Ceil)
+	add("burst", s.Burst)
+	add("qdisc", s.LeafQdisc)
+	add("delay", s.Delay)
+	add("jitter", s.Jitter)
+	add("loss", s.Loss)
+	return strings.Join(parts, " ")
+}
+
+func isLeafQdisc(name string) bool {
+	for _, q := range LeafQdiscs {
+		if q == name {
+			return true
+		}
+	}
+	return false
+}
+
+// leafQdiscArgs returns the qdisc to attach below a limited class
+func (s Spec) leafQdiscArgs() []string {
+	if s.HasImpairments() {
+		args := []string{"netem"}
+		if s.Delay != "" {
+			args = append(args, "delay", s.Delay)
+			if s.Jitter != "" {
+				args = append(args, s.Jitter)
+			}
+		}
+		if s.Loss != "" {
+			args = append(args, "loss", s.Loss)
+		}
+		return args
+	}
+	if s.LeafQdisc != "" {
+		return []string{s.LeafQdisc}
+	}
+	return nil
+}
+
+// htbClassArgs returns the htb class parameters for the given rate
+func (s Spec) htbClassArgs(rate string) []string {
+	args := []string{"htb", "rate", rate}
+	if s.Ceil != "" {
+		args = append(args, "ceil", s.Ceil)
+	}
+	if s.Burst != "" {
+		args = append(args, "burst", s.Burst)
+	}
+	return args
+}
@@ -1,10 +1,7 @@
 package arp
 
 import (
-	"encoding/binary"
-	"fmt"
 	"net"
-	"syscall"
 )
 
 // Converts a MAC address to a byte slice
@@ -23,48 +20,34 @@ func Htons(i uint16) uint16 {
 }
 
 // BuildPacket constructs a complete Ethernet + ARP packet
-func BuildPacket(senderMAC, targetMAC net.HardwareAddr, senderIP, targetIP net.IP, opcode uint16) []byte {
-	packet := make([]byte, 42) // Ethernet header (14 bytes) + ARP packet (28 bytes)
-
-	// Ethernet header
-	copy(packet[0:6], targetMAC)
-	copy(packet[6:12], senderMAC)
-	packet[12] = 0x08
-	packet[13] = 0x06
-
-	// ARP header
-	binary.BigEndian.PutUint16(packet[14:16], 1)      // Hardware type (Ethernet)
-	binary.BigEndian.PutUint16(packet[16:18], 0x0800) // Protocol type (IPv4)
-	packet[18] = 6                                    // Hardware size
-	packet[19] = 4                                    // Protocol size
-	binary.BigEndian.PutUint16(packet[20:22], opcode) // Opcode (1=request, 2=reply)
-
-	copy(packet[22:28], senderMAC)      // Sender MAC
-	copy(packet[28:32], senderIP.To4()) // Sender IP
-	copy(packet[32:38], targetMAC)      // Target MAC
-	copy(packet[38:42], targetIP.To4()) // Target IP
-
-	return packet
+func BuildPacket(senderMAC, targetMAC net.HardwareAddr, senderIP, targetIP net.IP, op Operation) []byte {
+	return BuildPacketFrom(senderMAC, senderMAC, targetMAC, senderIP, targetIP, op)
 }
 
-// Send sends a raw ARP packet using a raw socket
-func Send(iface *net.Interface, packet []byte, targetMAC net.HardwareAddr) error {
-	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(Htons(0x0806)))
-	if err != nil {
-		return fmt.Errorf("socket error: %v", err)
-	}
-	defer syscall.Close(fd)
-
-	addr := syscall.SockaddrLinklayer{
-		Protocol: Htons(0x0806),
-		Ifindex:  iface.Index,
-		Halen:    6,
+// BuildPacketFrom constructs an Ethernet + ARP packet whose Ethernet source
+// differs from the ARP sender, e.g. when announcing another host's address
+// without making switches learn its MAC on our port. Invalid addresses are
+// left zeroed; use Packet.Marshal to have them reported.
+func BuildPacketFrom(ethSrc, senderMAC, targetMAC net.HardwareAddr, senderIP, targetIP net.IP, op Operation) []byte {
+	p := &Packet{
+		EthDst:             targetMAC,
+		EthSrc:             ethSrc,
+		Operation:          op,
+		SenderHardwareAddr: senderMAC,
+		SenderIP:           senderIP,
+		TargetHardwareAddr: targetMAC,
+		TargetIP:           targetIP,
 	}
-	copy(addr.Addr[:], targetMAC)
+	return p.encode()
+}
 
-	err = syscall.Sendto(fd, packet, 0, &addr)
+// Send sends a single raw ARP packet using a throwaway socket. Use a Sender
+// when sending more than once.
+func Send(iface *net.Interface, packet []byte, targetMAC net.HardwareAddr) error {
+	sender, err := NewSender(iface)
 	if err != nil {
-		return fmt.Errorf("sendto error: %v", err)
+		return err
 	}
-	return nil
+	defer sender.Close()
+	return sender.Send(packet, targetMAC)
 }
@@ -0,0 +1,133 @@
+package arp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/prabalesh/slayer/internal/networking/vlan"
+)
+
+const (
+	packetOutgoing = 4      // sll_pkttype of frames we sent ourselves
+	ethPAll        = 0x0003 // ETH_P_ALL, the only taps that see a frame's VLAN tag
+)
+
+// filter is a classic BPF program run by the kernel so only Ethernet/IPv4
+// ARP frames are copied to the socket. Frames with an in-band 802.1Q header
+// are checked four bytes further in; the X register holds that offset.
+var filter = []syscall.SockFilter{
+	// X = 4 if the frame is tagged, 0 otherwise
+	*syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_W|syscall.BPF_IMM, 0),
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 12),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, vlan.TPID, 0, 1),
+	*syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_W|syscall.BPF_IMM, vlan.HeaderLen),
+	// EtherType == ARP
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 12),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, EtherTypeARP, 0, 9),
+	// Hardware type == Ethernet
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 14),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, hardwareEther, 0, 7),
+	// Protocol type == IPv4
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 16),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, EtherTypeIPv4, 0, 5),
+	// Hardware size == 6
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_IND, 18),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 6, 0, 3),
+	// Protocol size == 4
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_IND, 19),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 4, 0, 1),
+	// Accept the whole frame
+	*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0xffff),
+	// Drop
+	*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+}
+
+// Listener receives ARP packets sent by other hosts on an interface.
+type Listener struct {
+	iface *net.Interface
+	vlan  uint16
+	fd    int
+	buf   []byte
+	oob   []byte
+}
+
+// NewListener opens a raw socket bound to iface with a kernel filter passing
+// only Ethernet/IPv4 ARP. Tagged frames seen on a trunk are ignored. Reads
+// return after at most timeout so callers can check for shutdown.
+func NewListener(iface *net.Interface, timeout time.Duration) (*Listener, error) {
+	return NewVLANListener(iface, 0, timeout)
+}
+
+// NewVLANListener is like NewListener but only passes packets tagged for the
+// given VLAN on a trunk interface. A VLAN of zero passes untagged packets.
+func NewVLANListener(iface *net.Interface, id uint16, timeout time.Duration) (*Listener, error) {
+	if id > vlan.MaxID {
+		return nil, ErrInvalidVLAN
+	}
+
+	// Sockets bound to one EtherType get tagged frames with the tag already
+	// dropped, so listen to everything and let the filter pick ARP
+	protocol := Htons(ethPAll)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(protocol))
+	if err != nil {
+		return nil, fmt.Errorf("socket error: %v", err)
+	}
+	if err := syscall.AttachLsf(fd, filter); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("attach filter error: %v", err)
+	}
+	if err := vlan.EnableAuxData(fd); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("setsockopt error: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: iface.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind to %s error: %v", iface.Name, err)
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("setsockopt error: %v", err)
+	}
+	return &Listener{iface: iface, vlan: id, fd: fd, buf: make([]byte, 1518), oob: make([]byte, vlan.OOBSize)}, nil
+}
+
+// Interface returns the interface the listener is bound to.
+func (l *Listener) Interface() *net.Interface {
+	return l.iface
+}
+
+// VLAN returns the VLAN the listener passes, zero if untagged.
+func (l *Listener) VLAN() uint16 {
+	return l.vlan
+}
+
+// Read returns the next packet received from another host. It returns a nil
+// packet and no error when the read timeout expires.
+func (l *Listener) Read() (*Packet, error) {
+	for {
+		frame, id, pkttype, err := vlan.Recv(l.fd, l.buf, l.oob)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recvmsg error: %v", err)
+		}
+		if pkttype == packetOutgoing || id != l.vlan {
+			continue
+		}
+		p, err := Unmarshal(frame)
+		if err != nil {
+			continue // the filter lets through frames with a bad opcode
+		}
+		p.VLAN = id
+		return p, nil
+	}
+}
+
+// Close releases the socket.
+func (l *Listener) Close() error {
+	return syscall.Close(l.fd)
+}
@@ -0,0 +1,258 @@
+package arp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/prabalesh/slayer/internal/networking/vlan"
+)
+
+// Operation is an ARP opcode.
+type Operation uint16
+
+// Operations defined by RFC 826
+const (
+	OperationRequest Operation = 1
+	OperationReply   Operation = 2
+)
+
+// Frame layout of Ethernet carrying IPv4 ARP
+const (
+	EtherTypeARP  = 0x0806
+	EtherTypeIPv4 = 0x0800
+	HeaderLen     = 14 // Ethernet header
+	PacketLen     = 28 // ARP payload for Ethernet/IPv4
+	FrameLen      = HeaderLen + PacketLen
+	hardwareEther = 1
+)
+
+var (
+	// Broadcast is the Ethernet broadcast address
+	Broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	zeroMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+)
+
+// Errors returned by Unmarshal and Validate
+var (
+	ErrTruncated     = errors.New("arp: frame too short")
+	ErrNotARP        = errors.New("arp: not an ARP frame")
+	ErrUnsupported   = errors.New("arp: not Ethernet/IPv4 ARP")
+	ErrInvalidMAC    = errors.New("arp: invalid hardware address")
+	ErrInvalidIP     = errors.New("arp: invalid IPv4 address")
+	ErrInvalidOpcode = errors.New("arp: invalid operation")
+	ErrInvalidVLAN   = errors.New("arp: invalid VLAN ID")
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationRequest:
+		return "request"
+	case OperationReply:
+		return "reply"
+	default:
+		return fmt.Sprintf("operation(%d)", uint16(o))
+	}
+}
+
+// Packet is an Ethernet frame carrying an IPv4 ARP packet.
+type Packet struct {
+	EthDst net.HardwareAddr // Ethernet destination
+	EthSrc net.HardwareAddr // Ethernet source, usually the sender's MAC
+	VLAN   uint16           // 802.1Q VLAN ID, zero for untagged frames
+
+	Operation          Operation
+	SenderHardwareAddr net.HardwareAddr
+	SenderIP           net.IP
+	TargetHardwareAddr net.HardwareAddr
+	TargetIP           net.IP
+}
+
+// NewRequest asks who has targetIP, broadcast from senderMAC.
+func NewRequest(senderMAC net.HardwareAddr, senderIP, targetIP net.IP) *Packet {
+	return &Packet{
+		EthDst:             Broadcast,
+		EthSrc:             senderMAC,
+		Operation:          OperationRequest,
+		SenderHardwareAddr: senderMAC,
+		SenderIP:           senderIP,
+		TargetHardwareAddr: zeroMAC,
+		TargetIP:           targetIP,
+	}
+}
+
+// NewReply tells targetMAC that senderIP is at senderMAC.
+func NewReply(senderMAC net.HardwareAddr, senderIP net.IP, targetMAC net.HardwareAddr, targetIP net.IP) *Packet {
+	return &Packet{
+		EthDst:             targetMAC,
+		EthSrc:             senderMAC,
+		Operation:          OperationReply,
+		SenderHardwareAddr: senderMAC,
+		SenderIP:           senderIP,
+		TargetHardwareAddr: targetMAC,
+		TargetIP:           targetIP,
+	}
+}
+
+// NewGratuitous broadcasts an unsolicited reply announcing that ip is at mac,
+// updating every cache that already holds ip.
+func NewGratuitous(mac net.HardwareAddr, ip net.IP) *Packet {
+	return &Packet{
+		EthDst:             Broadcast,
+		EthSrc:             mac,
+		Operation:          OperationReply,
+		SenderHardwareAddr: mac,
+		SenderIP:           ip,
+		TargetHardwareAddr: Broadcast,
+		TargetIP:           ip,
+	}
+}
+
+// NewProbe builds an RFC 5227 probe checking whether ip is in use. The sender
+// IP is all zeros so no cache learns from it.
+func NewProbe(mac net.HardwareAddr, ip net.IP) *Packet {
+	return &Packet{
+		EthDst:             Broadcast,
+		EthSrc:             mac,
+		Operation:          OperationRequest,
+		SenderHardwareAddr: mac,
+		SenderIP:           net.IPv4zero,
+		TargetHardwareAddr: zeroMAC,
+		TargetIP:           ip,
+	}
+}
+
+// NewAnnouncement builds an RFC 5227 announcement claiming ip for mac.
+func NewAnnouncement(mac net.HardwareAddr, ip net.IP) *Packet {
+	return &Packet{
+		EthDst:             Broadcast,
+		EthSrc:             mac,
+		Operation:          OperationRequest,
+		SenderHardwareAddr: mac,
+		SenderIP:           ip,
+		TargetHardwareAddr: zeroMAC,
+		TargetIP:           ip,
+	}
+}
+
+// IsProbe reports whether p is an RFC 5227 probe.
+func (p *Packet) IsProbe() bool {
+	return p.Operation == OperationRequest && p.SenderIP.Equal(net.IPv4zero)
+}
+
+// IsGratuitous reports whether p announces the sender's own address, either
+// as a gratuitous reply or an RFC 5227 announcement.
+func (p *Packet) IsGratuitous() bool {
+	return !p.SenderIP.Equal(net.IPv4zero) && p.SenderIP.Equal(p.TargetIP)
+}
+
+// Validate checks that every field fits an Ethernet/IPv4 ARP frame.
+func (p *Packet) Validate() error {
+	for _, mac := range []net.HardwareAddr{p.EthDst, p.EthSrc, p.SenderHardwareAddr, p.TargetHardwareAddr} {
+		if len(mac) != 6 {
+			return ErrInvalidMAC
+		}
+	}
+	for _, ip := range []net.IP{p.SenderIP, p.TargetIP} {
+		if ip.To4() == nil {
+			return ErrInvalidIP
+		}
+	}
+	if p.Operation != OperationRequest && p.Operation != OperationReply {
+		return ErrInvalidOpcode
+	}
+	if p.VLAN > vlan.MaxID {
+		return ErrInvalidVLAN
+	}
+	return nil
+}
+
+// Marshal encodes the packet as an Ethernet frame, with an 802.1Q header
+// when VLAN is set.
+func (p *Packet) Marshal() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p.encode(), nil
+}
+
+// encode lays out the frame without validating it
+func (p *Packet) encode() []byte {
+	frame := make([]byte, FrameLen)
+
+	// Ethernet header
+	copy(frame[0:6], p.EthDst)
+	copy(frame[6:12], p.EthSrc)
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeARP)
+
+	// ARP header
+	binary.BigEndian.PutUint16(frame[14:16], hardwareEther)
+	binary.BigEndian.PutUint16(frame[16:18], EtherTypeIPv4)
+	frame[18] = 6 // Hardware size
+	frame[19] = 4 // Protocol size
+	binary.BigEndian.PutUint16(frame[20:22], uint16(p.Operation))
+
+	copy(frame[22:28], p.SenderHardwareAddr)
+	copy(frame[28:32], p.SenderIP.To4())
+	copy(frame[32:38], p.TargetHardwareAddr)
+	copy(frame[38:42], p.TargetIP.To4())
+
+	return vlan.Tag(frame, p.VLAN)
+}
+
+// Unmarshal decodes an Ethernet frame, tagged or not. The returned packet
+// does not share memory with frame.
+func Unmarshal(frame []byte) (*Packet, error) {
+	frame, id := vlan.Untag(frame)
+	if len(frame) < FrameLen {
+		return nil, ErrTruncated
+	}
+	if binary.BigEndian.Uint16(frame[12:14]) != EtherTypeARP {
+		return nil, ErrNotARP
+	}
+	if binary.BigEndian.Uint16(frame[14:16]) != hardwareEther ||
+		binary.BigEndian.Uint16(frame[16:18]) != EtherTypeIPv4 ||
+		frame[18] != 6 || frame[19] != 4 {
+		return nil, ErrUnsupported
+	}
+
+	data := make([]byte, FrameLen)
+	copy(data, frame)
+	p := &Packet{
+		EthDst:             net.HardwareAddr(data[0:6]),
+		EthSrc:             net.HardwareAddr(data[6:12]),
+		VLAN:               id,
+		Operation:          Operation(binary.BigEndian.Uint16(data[20:22])),
+		SenderHardwareAddr: net.HardwareAddr(data[22:28]),
+		SenderIP:           net.IP(data[28:32]),
+		TargetHardwareAddr: net.HardwareAddr(data[32:38]),
+		TargetIP:           net.IP(data[38:42]),
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Frame encodes the packet for a Sender, addressed to its Ethernet
+// destination.
+func (p *Packet) Frame() (Frame, error) {
+	data, err := p.Marshal()
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Packet: data, Dst: p.EthDst}, nil
+}
+
+func (p *Packet) String() string {
+	switch p.Operation {
+	case OperationRequest:
+		return fmt.Sprintf("who-has %s tell %s (%s)", p.TargetIP, p.SenderIP, p.SenderHardwareAddr)
+	case OperationReply:
+		return fmt.Sprintf("%s is-at %s", p.SenderIP, p.SenderHardwareAddr)
+	default:
+		return p.Operation.String()
+	}
+}
@@ -0,0 +1,252 @@
+package arp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+var (
+	testMAC    = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	testPeer   = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	testIP     = net.IPv4(192, 168, 1, 10)
+	testPeerIP = net.IPv4(192, 168, 1, 1)
+)
+
+func TestConstructors(t *testing.T) {
+	tests := []struct {
+		name       string
+		packet     *Packet
+		ethDst     net.HardwareAddr
+		operation  Operation
+		senderIP   net.IP
+		targetMAC  net.HardwareAddr
+		targetIP   net.IP
+		probe      bool
+		gratuitous bool
+	}{
+		{"request", NewRequest(testMAC, testIP, testPeerIP), Broadcast, OperationRequest, testIP, zeroMAC, testPeerIP, false, false},
+		{"reply", NewReply(testMAC, testIP, testPeer, testPeerIP), testPeer, OperationReply, testIP, testPeer, testPeerIP, false, false},
+		{"gratuitous", NewGratuitous(testMAC, testIP), Broadcast, OperationReply, testIP, Broadcast, testIP, false, true},
+		{"probe", NewProbe(testMAC, testIP), Broadcast, OperationRequest, net.IPv4zero, zeroMAC, testIP, true, false},
+		{"announcement", NewAnnouncement(testMAC, testIP), Broadcast, OperationRequest, testIP, zeroMAC, testIP, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.packet
+			if !bytes.Equal(p.EthDst, tt.ethDst) {
+				t.Errorf("EthDst = %s, want %s", p.EthDst, tt.ethDst)
+			}
+			if !bytes.Equal(p.EthSrc, testMAC) || !bytes.Equal(p.SenderHardwareAddr, testMAC) {
+				t.Errorf("EthSrc = %s, SenderHardwareAddr = %s, want %s", p.EthSrc, p.SenderHardwareAddr, testMAC)
+			}
+			if p.Operation != tt.operation {
+				t.Errorf("Operation = %s, want %s", p.Operation, tt.operation)
+			}
+			if !p.SenderIP.Equal(tt.senderIP) {
+				t.Errorf("SenderIP = %s, want %s", p.SenderIP, tt.senderIP)
+			}
+			if !bytes.Equal(p.TargetHardwareAddr, tt.targetMAC) {
+				t.Errorf("TargetHardwareAddr = %s, want %s", p.TargetHardwareAddr, tt.targetMAC)
+			}
+			if !p.TargetIP.Equal(tt.targetIP) {
+				t.Errorf("TargetIP = %s, want %s", p.TargetIP, tt.targetIP)
+			}
+			if got := p.IsProbe(); got != tt.probe {
+				t.Errorf("IsProbe() = %v, want %v", got, tt.probe)
+			}
+			if got := p.IsGratuitous(); got != tt.gratuitous {
+				t.Errorf("IsGratuitous() = %v, want %v", got, tt.gratuitous)
+			}
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestIsProbeIsGratuitous(t *testing.T) {
+	tests := []struct {
+		name       string
+		operation  Operation
+		senderIP   net.IP
+		targetIP   net.IP
+		probe      bool
+		gratuitous bool
+	}{
+		{"request", OperationRequest, testIP, testPeerIP, false, false},
+		{"reply", OperationReply, testIP, testPeerIP, false, false},
+		{"probe", OperationRequest, net.IPv4zero, testIP, true, false},
+		{"probe for zero", OperationRequest, net.IPv4zero, net.IPv4zero, true, false},
+		{"reply from zero", OperationReply, net.IPv4zero, testIP, false, false},
+		{"announcement", OperationRequest, testIP, testIP, false, true},
+		{"gratuitous reply", OperationReply, testIP, testIP, false, true},
+		{"16-byte sender", OperationReply, testIP.To16(), testIP.To4(), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Packet{Operation: tt.operation, SenderIP: tt.senderIP, TargetIP: tt.targetIP}
+			if got := p.IsProbe(); got != tt.probe {
+				t.Errorf("IsProbe() = %v, want %v", got, tt.probe)
+			}
+			if got := p.IsGratuitous(); got != tt.gratuitous {
+				t.Errorf("IsGratuitous() = %v, want %v", got, tt.gratuitous)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *Packet)
+		err    error
+	}{
+		{"valid", func(p *Packet) {}, nil},
+		{"highest VLAN", func(p *Packet) { p.VLAN = 4094 }, nil},
+		{"short EthDst", func(p *Packet) { p.EthDst = p.EthDst[:5] }, ErrInvalidMAC},
+		{"missing EthSrc", func(p *Packet) { p.EthSrc = nil }, ErrInvalidMAC},
+		{"long sender MAC", func(p *Packet) { p.SenderHardwareAddr = append(net.HardwareAddr{0}, testMAC...) }, ErrInvalidMAC},
+		{"missing target MAC", func(p *Packet) { p.TargetHardwareAddr = nil }, ErrInvalidMAC},
+		{"IPv6 sender", func(p *Packet) { p.SenderIP = net.ParseIP("fe80::1") }, ErrInvalidIP},
+		{"missing target IP", func(p *Packet) { p.TargetIP = nil }, ErrInvalidIP},
+		{"zero operation", func(p *Packet) { p.Operation = 0 }, ErrInvalidOpcode},
+		{"RARP operation", func(p *Packet) { p.Operation = 3 }, ErrInvalidOpcode},
+		{"VLAN too high", func(p *Packet) { p.VLAN = 4095 }, ErrInvalidVLAN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewReply(testMAC, testIP, testPeer, testPeerIP)
+			tt.modify(p)
+			if err := p.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("Validate() = %v, want %v", err, tt.err)
+			}
+			if _, err := p.Marshal(); !errors.Is(err, tt.err) {
+				t.Errorf("Marshal() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	tagged := NewRequest(testMAC, testIP, testPeerIP)
+	tagged.VLAN = 42
+	tests := []struct {
+		name   string
+		packet *Packet
+		length int
+	}{
+		{"request", NewRequest(testMAC, testIP, testPeerIP), FrameLen},
+		{"reply", NewReply(testMAC, testIP, testPeer, testPeerIP), FrameLen},
+		{"gratuitous", NewGratuitous(testMAC, testIP), FrameLen},
+		{"probe", NewProbe(testMAC, testIP), FrameLen},
+		{"announcement", NewAnnouncement(testMAC, testIP), FrameLen},
+		{"tagged", tagged, FrameLen + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := tt.packet.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() = %v", err)
+			}
+			if len(frame) != tt.length {
+				t.Fatalf("len(frame) = %d, want %d", len(frame), tt.length)
+			}
+
+			got, err := Unmarshal(frame)
+			if err != nil {
+				t.Fatalf("Unmarshal() = %v", err)
+			}
+			want := tt.packet
+			if !bytes.Equal(got.EthDst, want.EthDst) || !bytes.Equal(got.EthSrc, want.EthSrc) ||
+				got.VLAN != want.VLAN || got.Operation != want.Operation ||
+				!bytes.Equal(got.SenderHardwareAddr, want.SenderHardwareAddr) || !got.SenderIP.Equal(want.SenderIP) ||
+				!bytes.Equal(got.TargetHardwareAddr, want.TargetHardwareAddr) || !got.TargetIP.Equal(want.TargetIP) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, want)
+			}
+
+			// The packet must not alias the frame
+			for i := range frame {
+				frame[i] = 0
+			}
+			if !got.SenderIP.Equal(want.SenderIP) {
+				t.Errorf("SenderIP changed with the frame: %s", got.SenderIP)
+			}
+		})
+	}
+}
+
+func TestMarshalLayout(t *testing.T) {
+	frame, err := NewRequest(testMAC, testIP, testPeerIP).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	want := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // EthDst
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // EthSrc
+		0x08, 0x06, // EtherType
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, // Ethernet/IPv4
+		0x00, 0x01, // Request
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 192, 168, 1, 10, // Sender
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 192, 168, 1, 1, // Target
+	}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("Marshal() =\n% x\nwant\n% x", frame, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, err := NewReply(testMAC, testIP, testPeer, testPeerIP).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	modified := func(modify func(frame []byte)) []byte {
+		frame := append([]byte(nil), valid...)
+		modify(frame)
+		return frame
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"header only", valid[:HeaderLen], ErrTruncated},
+		{"one byte short", valid[:FrameLen-1], ErrTruncated},
+		{"tagged and short", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0x00, 0x00, 0x2a, 0x08, 0x06}, ErrTruncated},
+		{"IPv4 frame", modified(func(f []byte) { f[12], f[13] = 0x08, 0x00 }), ErrNotARP},
+		{"token ring hardware", modified(func(f []byte) { f[15] = 6 }), ErrUnsupported},
+		{"IPv6 protocol", modified(func(f []byte) { f[16], f[17] = 0x86, 0xdd }), ErrUnsupported},
+		{"hardware size", modified(func(f []byte) { f[18] = 8 }), ErrUnsupported},
+		{"protocol size", modified(func(f []byte) { f[19] = 16 }), ErrUnsupported},
+		{"bad operation", modified(func(f []byte) { f[21] = 9 }), ErrInvalidOpcode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Unmarshal(tt.frame)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Unmarshal() = %v, want %v", err, tt.err)
+			}
+			if p != nil {
+				t.Errorf("Unmarshal() returned a packet with error %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalPadded(t *testing.T) {
+	// Short frames are padded to the Ethernet minimum on the wire
+	frame, err := NewGratuitous(testMAC, testIP).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	p, err := Unmarshal(append(frame, make([]byte, 18)...))
+	if err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if !p.IsGratuitous() || !p.SenderIP.Equal(testIP) {
+		t.Errorf("Unmarshal() = %+v", p)
+	}
+}
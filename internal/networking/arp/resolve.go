@@ -0,0 +1,76 @@
+package arp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrNoReply is returned by Resolve when nobody answered in time
+var ErrNoReply = errors.New("arp: no reply")
+
+// Resolve asks for ip's MAC address on iface and waits up to timeout for the
+// reply.
+func Resolve(iface *net.Interface, ip net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	sourceIP, err := InterfaceIPv4(iface)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveVLAN(iface, 0, sourceIP, ip, timeout)
+}
+
+// ResolveVLAN asks for ip's MAC address on a VLAN of a trunk interface. The
+// trunk usually has no address on that VLAN, so the request is sent from
+// sourceIP, typically the address of the matching VLAN sub-interface.
+func ResolveVLAN(iface *net.Interface, id uint16, sourceIP, ip net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	listener, err := NewVLANListener(iface, id, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	sender, err := NewVLANSender(iface, id)
+	if err != nil {
+		return nil, err
+	}
+	defer sender.Close()
+
+	request, err := NewRequest(iface.HardwareAddr, sourceIP, ip).Frame()
+	if err != nil {
+		return nil, err
+	}
+	if err := sender.Send(request.Packet, request.Dst); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		p, err := listener.Read()
+		if err != nil {
+			return nil, err
+		}
+		if p != nil && p.Operation == OperationReply && p.SenderIP.Equal(ip) &&
+			(bytes.Equal(p.TargetHardwareAddr, iface.HardwareAddr) || p.IsGratuitous()) {
+			return p.SenderHardwareAddr, nil
+		}
+	}
+	return nil, ErrNoReply
+}
+
+// InterfaceIPv4 returns the first IPv4 address assigned to iface.
+func InterfaceIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", iface.Name, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address on %s", iface.Name)
+}
@@ -0,0 +1,126 @@
+package arp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/prabalesh/slayer/internal/networking/vlan"
+)
+
+// ErrClosed is returned when sending on a closed Sender
+var ErrClosed = errors.New("arp sender is closed")
+
+// Frame is a packet and the link-layer address it is sent to
+type Frame struct {
+	Packet []byte
+	Dst    net.HardwareAddr
+}
+
+// Sender keeps one AF_PACKET socket open on an interface for sending ARP
+// frames, or any other complete Ethernet frame. It is safe for concurrent use.
+type Sender struct {
+	iface *net.Interface
+	vlan  uint16 // tag added to untagged frames, zero to send them as is
+	fd    int
+	mu    sync.RWMutex // held for reading while sending, for writing on Close
+	open  bool
+}
+
+// NewSender opens a raw socket bound to iface.
+func NewSender(iface *net.Interface) (*Sender, error) {
+	return NewVLANSender(iface, 0)
+}
+
+// NewVLANSender opens a raw socket on a trunk interface that tags every
+// frame for the given VLAN. A VLAN of zero sends frames untagged.
+func NewVLANSender(iface *net.Interface, id uint16) (*Sender, error) {
+	if id > vlan.MaxID {
+		return nil, ErrInvalidVLAN
+	}
+	// Protocol zero receives nothing, so no frames queue up on a socket that
+	// is only written to. Each frame's protocol is given to sendto.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("socket error: %v", err)
+	}
+	return &Sender{iface: iface, vlan: id, fd: fd, open: true}, nil
+}
+
+// Interface returns the interface the sender is bound to.
+func (s *Sender) Interface() *net.Interface {
+	return s.iface
+}
+
+// VLAN returns the VLAN frames are tagged for, zero if untagged.
+func (s *Sender) VLAN() uint16 {
+	return s.vlan
+}
+
+// Send writes one frame to dst.
+func (s *Sender) Send(packet []byte, dst net.HardwareAddr) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.open {
+		return ErrClosed
+	}
+	return s.sendto(packet, dst)
+}
+
+// SendBatch writes all frames, continuing past failures. The returned error
+// joins every failed send.
+func (s *Sender) SendBatch(frames []Frame) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.open {
+		return ErrClosed
+	}
+
+	var errs []error
+	for _, frame := range frames {
+		if err := s.sendto(frame.Packet, frame.Dst); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases the socket. Later sends return ErrClosed.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return syscall.Close(s.fd)
+}
+
+func (s *Sender) sendto(packet []byte, dst net.HardwareAddr) error {
+	if s.vlan != 0 && !vlan.IsTagged(packet) {
+		packet = vlan.Tag(packet, s.vlan)
+	}
+	// Frames other than ARP, e.g. neighbor advertisements, go out as is
+	protocol := uint16(0x0806)
+	if len(packet) >= HeaderLen {
+		protocol = binary.BigEndian.Uint16(packet[12:14])
+	}
+
+	addr := syscall.SockaddrLinklayer{
+		Protocol: Htons(protocol),
+		Ifindex:  s.iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], dst)
+
+	if err := syscall.Sendto(s.fd, packet, 0, &addr); err != nil {
+		return fmt.Errorf("sendto %s error: %v", dst, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Package bridge sets up the Linux bridge slayer shapes on when it sits
+// inline between hosts and their uplink, and finds hosts from bridged traffic.
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	netfilterProc  = "/proc/sys/net/bridge" // exists once br_netfilter is loaded
+	ethPAll        = 0x0003
+	ethPIPv4       = 0x0800
+	ethPARP        = 0x0806
+	packetOutgoing = 4 // sll_pkttype of frames we sent ourselves
+	// captureTimeout bounds each read so Discover notices its deadline
+	captureTimeout = 100 * time.Millisecond
+)
+
+// Bridge is a Linux bridge and the interfaces enslaved to it.
+type Bridge struct {
+	Name  string
+	Ports []string
+}
+
+// Neighbor is an IPv4 address seen on a bridge port and the MAC using it.
+type Neighbor struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+type link struct {
+	Name     string `json:"ifname"`
+	Master   string `json:"master"`
+	LinkInfo struct {
+		Kind string `json:"info_kind"`
+	} `json:"linkinfo"`
+}
+
+// links returns every link with its kind and master, as listed by ip
+func links() ([]link, error) {
+	out, err := exec.Command("ip", "-d", "-j", "link", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %v", err)
+	}
+	var all []link
+	if len(strings.TrimSpace(string(out))) > 0 {
+		if err := json.Unmarshal(out, &all); err != nil {
+			return nil, fmt.Errorf("failed to parse links: %v", err)
+		}
+	}
+	return all, nil
+}
+
+// Lookup returns the bridge named name and its ports, if it exists.
+func Lookup(name string) (*Bridge, bool, error) {
+	all, err := links()
+	if err != nil {
+		return nil, false, err
+	}
+	var bridge *Bridge
+	for _, l := range all {
+		if l.Name != name {
+			continue
+		}
+		if l.LinkInfo.Kind != "bridge" {
+			return nil, false, fmt.Errorf("%s is not a bridge", name)
+		}
+		bridge = &Bridge{Name: name}
+	}
+	if bridge == nil {
+		return nil, false, nil
+	}
+	for _, l := range all {
+		if l.Master == name {
+			bridge.Ports = append(bridge.Ports, l.Name)
+		}
+	}
+	return bridge, true, nil
+}
+
+// Attach returns the bridge named name with ports enslaved to it, creating
+// the bridge and adding missing ports as needed. Addresses on enslaved
+// ports stop working, so pass bare interfaces. The bridge is left in place
+// on exit so hosts keep their uplink.
+func Attach(name string, ports ...string) (*Bridge, error) {
+	bridge, exists, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := run("ip", "link", "add", "name", name, "type", "bridge"); err != nil {
+			return nil, fmt.Errorf("failed to create bridge %s: %v", name, err)
+		}
+		bridge = &Bridge{Name: name}
+	}
+
+	for _, port := range ports {
+		if slices.Contains(bridge.Ports, port) {
+			continue
+		}
+		if err := run("ip", "link", "set", port, "master", name); err != nil {
+			return nil, fmt.Errorf("failed to add %s to bridge %s: %v", port, name, err)
+		}
+		bridge.Ports = append(bridge.Ports, port)
+	}
+	for _, dev := range append([]string{name}, ports...) {
+		if err := run("ip", "link", "set", dev, "up"); err != nil {
+			return nil, fmt.Errorf("failed to bring up %s: %v", dev, err)
+		}
+	}
+	return bridge, nil
+}
+
+// LoadNetfilter loads br_netfilter so bridged IPv4 and IPv6 frames can be
+// marked and filtered by iptables. The net.bridge.bridge-nf-call-* settings
+// must still be set to 1.
+func LoadNetfilter() error {
+	if _, err := os.Stat(netfilterProc); err == nil {
+		return nil
+	}
+	if err := run("modprobe", "br_netfilter"); err != nil {
+		return fmt.Errorf("failed to load br_netfilter: %v", err)
+	}
+	return nil
+}
+
+// Discover finds the hosts behind port by watching the frames it receives
+// for wait: the sender of every ARP packet and the source of every IPv4
+// packet. Only hosts on port's side of the bridge are reported, since
+// frames from elsewhere arrive on other ports.
+func Discover(port *net.Interface, wait time.Duration) ([]Neighbor, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPAll)))
+	if err != nil {
+		return nil, fmt.Errorf("socket error: %v", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: port.Index}); err != nil {
+		return nil, fmt.Errorf("bind to %s error: %v", port.Name, err)
+	}
+	tv := syscall.NsecToTimeval(captureTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, fmt.Errorf("setsockopt error: %v", err)
+	}
+
+	seen := make(map[string]Neighbor)
+	buf := make([]byte, 64) // headers are enough
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		n, from, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recvfrom error: %v", err)
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		if neighbor, ok := parseSource(buf[:min(n, len(buf))]); ok {
+			seen[neighbor.IP.String()] = neighbor
+		}
+	}
+
+	neighbors := make([]Neighbor, 0, len(seen))
+	for _, neighbor := range seen {
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors, nil
+}
+
+// parseSource returns the address and MAC of the host that sent an ARP or
+// IPv4 frame. Probes from 0.0.0.0 and non-unicast sources are ignored.
+func parseSource(frame []byte) (Neighbor, bool) {
+	if len(frame) < 14 {
+		return Neighbor{}, false
+	}
+	mac := net.HardwareAddr(append([]byte(nil), frame[6:12]...))
+
+	var ip net.IP
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case ethPARP:
+		// Ethernet/IPv4 ARP: sender protocol address follows the sender MAC
+		if len(frame) < 14+28 {
+			return Neighbor{}, false
+		}
+		ip = net.IP(append([]byte(nil), frame[28:32]...))
+		mac = net.HardwareAddr(append([]byte(nil), frame[22:28]...))
+	case ethPIPv4:
+		if len(frame) < 14+20 {
+			return Neighbor{}, false
+		}
+		ip = net.IP(append([]byte(nil), frame[26:30]...))
+	default:
+		return Neighbor{}, false
+	}
+
+	if ip.IsUnspecified() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) || !isUnicastMAC(mac) {
+		return Neighbor{}, false
+	}
+	return Neighbor{IP: ip, MAC: mac}, true
+}
+
+func isUnicastMAC(mac net.HardwareAddr) bool {
+	return mac[0]&1 == 0 && !bytes.Equal(mac, make(net.HardwareAddr, len(mac)))
+}
+
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// htons converts a uint16 from host to network byte order
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
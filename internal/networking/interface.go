@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os/exec"
 	"strings"
 )
 
@@ -29,6 +30,44 @@ func GetActiveWiFiInterface() (*net.Interface, error) {
 	return nil, fmt.Errorf("no active Wi-Fi interface found")
 }
 
+// GetDefaultRouteInterface returns the interface the default route goes out
+// of, e.g. a wired port or a VLAN device like eth0.100.
+func GetDefaultRouteInterface() (*net.Interface, error) {
+	out, err := exec.Command("ip", "route", "show", "default").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ip route: %v", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "dev" && i+1 < len(fields) {
+				return net.InterfaceByName(fields[i+1])
+			}
+		}
+	}
+	return nil, fmt.Errorf("default route not found")
+}
+
+// GetActiveInterface returns the active Wi-Fi interface, or the default
+// route's interface when there is none.
+func GetActiveInterface() (*net.Interface, error) {
+	if iface, err := GetActiveWiFiInterface(); err == nil {
+		return iface, nil
+	}
+	return GetDefaultRouteInterface()
+}
+
+// LookupInterface returns the named interface, unlike GetInterfaceByName
+// reporting a missing one as an error.
+func LookupInterface(interfaceName string) (*net.Interface, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get interface %s: %v", interfaceName, err)
+	}
+	return iface, nil
+}
+
 func GetInterfaceByName(interfaceName string) *net.Interface {
 	iface, err := net.InterfaceByName(interfaceName)
 	if err != nil {
@@ -69,7 +69,25 @@ func GetDefaultGatewayIP() (net.IP, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to run ip route: %v", err)
 	}
+	return parseDefaultGateway(out)
+}
+
+// GetDefaultGatewayIPOn returns the default gateway reached through the
+// given interface, e.g. the router of one VLAN.
+func GetDefaultGatewayIPOn(interfaceName string) (net.IP, error) {
+	out, err := exec.Command("ip", "route", "show", "dev", interfaceName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ip route: %v", err)
+	}
+	ip, err := parseDefaultGateway(out)
+	if err != nil {
+		return nil, fmt.Errorf("default gateway not found on %s", interfaceName)
+	}
+	return ip, nil
+}
 
+// parseDefaultGateway finds the first "default via" route in ip route output
+func parseDefaultGateway(out []byte) (net.IP, error) {
 	lines := strings.Split(string(out), "\n")
 	for _, line := range lines {
 		if strings.HasPrefix(line, "default") {
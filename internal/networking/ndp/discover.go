@@ -0,0 +1,215 @@
+package ndp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNoIPv6 is returned by Discover when the interface has no IPv6 address
+var ErrNoIPv6 = errors.New("ndp: no IPv6 address")
+
+const (
+	packetOutgoing = 4 // sll_pkttype of frames we sent ourselves
+	// captureTimeout bounds each read so Discover notices its deadline
+	captureTimeout = 100 * time.Millisecond
+)
+
+// Neighbor is an IPv6 address used on the link and the MAC behind it.
+type Neighbor struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+// DefaultRouter returns the address of the IPv6 default router reached
+// through iface, usually link-local.
+func DefaultRouter(iface *net.Interface) (net.IP, error) {
+	out, err := exec.Command("ip", "-6", "route", "show", "default", "dev", iface.Name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ip route: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "via" && i+1 < len(fields) {
+				if ip := net.ParseIP(fields[i+1]); ip != nil {
+					return ip, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no IPv6 default route on %s", iface.Name)
+}
+
+// Neighbors returns the kernel's IPv6 neighbor table for iface.
+func Neighbors(iface *net.Interface) ([]Neighbor, error) {
+	out, err := exec.Command("ip", "-6", "-j", "neigh", "show", "dev", iface.Name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ip neigh: %v", err)
+	}
+
+	var entries []struct {
+		Dst    string `json:"dst"`
+		LLAddr string `json:"lladdr"`
+	}
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse ip neigh: %v", err)
+		}
+	}
+
+	var neighbors []Neighbor
+	for _, entry := range entries {
+		ip := net.ParseIP(entry.Dst)
+		mac, err := net.ParseMAC(entry.LLAddr)
+		if ip == nil || err != nil {
+			continue // incomplete or failed entries have no lladdr
+		}
+		neighbors = append(neighbors, Neighbor{IP: ip, MAC: mac})
+	}
+	return neighbors, nil
+}
+
+// Discover finds the IPv6 neighbors on iface. It pings all nodes from each of
+// our addresses, so both link-local and global addresses answer, and
+// collects every source address seen for wait, merged with the kernel's
+// neighbor table.
+func Discover(iface *net.Interface, wait time.Duration) ([]Neighbor, error) {
+	sources, err := ownAddresses(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(EtherTypeIPv6)))
+	if err != nil {
+		return nil, fmt.Errorf("socket error: %v", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(EtherTypeIPv6), Ifindex: iface.Index}); err != nil {
+		return nil, fmt.Errorf("bind to %s error: %v", iface.Name, err)
+	}
+	tv := syscall.NsecToTimeval(captureTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, fmt.Errorf("setsockopt error: %v", err)
+	}
+
+	var pingErr error
+	for _, source := range sources {
+		if err := pingAllNodes(iface, source); err != nil {
+			pingErr = err
+		}
+	}
+
+	seen := make(map[string]Neighbor)
+	buf := make([]byte, 1514)
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recvfrom error: %v", err)
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		if n < HeaderLen+IPv6HeaderLen {
+			continue
+		}
+		ip := net.IP(append([]byte(nil), buf[HeaderLen+8:HeaderLen+24]...))
+		if ip.IsUnspecified() || ip.IsMulticast() || containsIP(sources, ip) {
+			continue
+		}
+		seen[ip.String()] = Neighbor{IP: ip, MAC: net.HardwareAddr(append([]byte(nil), buf[6:12]...))}
+	}
+
+	table, err := Neighbors(iface)
+	if err != nil {
+		return nil, err
+	}
+	for _, neighbor := range table {
+		if _, exists := seen[neighbor.IP.String()]; !exists {
+			seen[neighbor.IP.String()] = neighbor
+		}
+	}
+
+	neighbors := make([]Neighbor, 0, len(seen))
+	for _, neighbor := range seen {
+		if bytes.Equal(neighbor.MAC, iface.HardwareAddr) {
+			continue
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	if len(neighbors) == 0 && pingErr != nil {
+		return nil, pingErr
+	}
+	return neighbors, nil
+}
+
+// ownAddresses returns the IPv6 addresses assigned to iface
+func ownAddresses(iface *net.Interface) ([]net.IP, error) {
+	if _, err := os.Stat("/proc/sys/net/ipv6"); err != nil {
+		return nil, ErrNoIPv6
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", iface.Name, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, ErrNoIPv6
+	}
+	return ips, nil
+}
+
+// pingAllNodes sends an echo request from source to every node on the link.
+// Replies come from an address in source's scope, and the kernel fills in
+// the checksum.
+func pingAllNodes(iface *net.Interface, source net.IP) error {
+	local := &net.IPAddr{IP: source}
+	if source.IsLinkLocalUnicast() {
+		local.Zone = iface.Name
+	}
+	conn, err := net.ListenIP("ip6:ipv6-icmp", local)
+	if err != nil {
+		return fmt.Errorf("failed to open ICMPv6 socket on %s: %v", source, err)
+	}
+	defer conn.Close()
+
+	echo := make([]byte, 8)
+	echo[0] = typeEchoRequest
+	binary.BigEndian.PutUint16(echo[4:6], uint16(os.Getpid()))
+	binary.BigEndian.PutUint16(echo[6:8], 1)
+	if _, err := conn.WriteToIP(echo, &net.IPAddr{IP: AllNodes, Zone: iface.Name}); err != nil {
+		return fmt.Errorf("failed to ping all nodes from %s: %v", source, err)
+	}
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// htons converts a uint16 from host to network byte order
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
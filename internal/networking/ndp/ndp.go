@@ -0,0 +1,152 @@
+// Package ndp builds IPv6 neighbor discovery messages and finds the IPv6
+// neighbors on a link.
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Frame layout of Ethernet carrying an ICMPv6 neighbor advertisement with a
+// target link-layer address option
+const (
+	EtherTypeIPv6   = 0x86dd
+	HeaderLen       = 14 // Ethernet header
+	IPv6HeaderLen   = 40
+	AdvertLen       = 32 // ICMPv6 header, flags, target and one option
+	FrameLen        = HeaderLen + IPv6HeaderLen + AdvertLen
+	ProtocolICMPv6  = 58
+	hopLimit        = 255 // receivers drop neighbor discovery with any other value
+	typeAdvert      = 136
+	typeEchoRequest = 128
+	optTargetLLA    = 2
+)
+
+// Flags are the neighbor advertisement flags.
+type Flags uint32
+
+// Flags defined by RFC 4861
+const (
+	FlagRouter    Flags = 1 << 31
+	FlagSolicited Flags = 1 << 30
+	FlagOverride  Flags = 1 << 29
+)
+
+// AllNodes is the link-local all-nodes multicast address
+var AllNodes = net.ParseIP("ff02::1")
+
+// Errors returned by Validate
+var (
+	ErrInvalidMAC = errors.New("ndp: invalid hardware address")
+	ErrInvalidIP  = errors.New("ndp: invalid IPv6 address")
+)
+
+// Advertisement is an Ethernet frame carrying a neighbor advertisement.
+type Advertisement struct {
+	EthDst net.HardwareAddr
+	EthSrc net.HardwareAddr
+	SrcIP  net.IP
+	DstIP  net.IP
+
+	Flags              Flags
+	Target             net.IP           // address being advertised
+	TargetHardwareAddr net.HardwareAddr // where Target is said to be
+}
+
+// NewAdvertisement tells the host at ethDst that target is at targetMAC. It
+// is unsolicited and overrides existing cache entries; set FlagRouter when
+// target belongs to a router, or receivers stop using it as one. The frame is
+// sent from ethSrc to the all-nodes address so no address of the receiver
+// needs to be known.
+func NewAdvertisement(ethSrc, ethDst net.HardwareAddr, target net.IP, targetMAC net.HardwareAddr, router bool) *Advertisement {
+	flags := FlagOverride
+	if router {
+		flags |= FlagRouter
+	}
+	return &Advertisement{
+		EthDst:             ethDst,
+		EthSrc:             ethSrc,
+		SrcIP:              target,
+		DstIP:              AllNodes,
+		Flags:              flags,
+		Target:             target,
+		TargetHardwareAddr: targetMAC,
+	}
+}
+
+// Validate checks that every field fits the frame.
+func (a *Advertisement) Validate() error {
+	for _, mac := range []net.HardwareAddr{a.EthDst, a.EthSrc, a.TargetHardwareAddr} {
+		if len(mac) != 6 {
+			return ErrInvalidMAC
+		}
+	}
+	for _, ip := range []net.IP{a.SrcIP, a.DstIP, a.Target} {
+		if len(ip) != net.IPv6len || ip.To4() != nil {
+			return ErrInvalidIP
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the advertisement as an Ethernet frame.
+func (a *Advertisement) Marshal() ([]byte, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a.encode(), nil
+}
+
+// encode lays out the frame without validating it
+func (a *Advertisement) encode() []byte {
+	frame := make([]byte, FrameLen)
+
+	// Ethernet header
+	copy(frame[0:6], a.EthDst)
+	copy(frame[6:12], a.EthSrc)
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPv6)
+
+	// IPv6 header
+	ip := frame[HeaderLen : HeaderLen+IPv6HeaderLen]
+	ip[0] = 6 << 4 // Version, traffic class and flow label zero
+	binary.BigEndian.PutUint16(ip[4:6], AdvertLen)
+	ip[6] = ProtocolICMPv6
+	ip[7] = hopLimit
+	copy(ip[8:24], a.SrcIP.To16())
+	copy(ip[24:40], a.DstIP.To16())
+
+	// ICMPv6 neighbor advertisement
+	icmp := frame[HeaderLen+IPv6HeaderLen:]
+	icmp[0] = typeAdvert
+	binary.BigEndian.PutUint32(icmp[4:8], uint32(a.Flags))
+	copy(icmp[8:24], a.Target.To16())
+	icmp[24] = optTargetLLA
+	icmp[25] = 1 // Option length in units of 8 bytes
+	copy(icmp[26:32], a.TargetHardwareAddr)
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(a.SrcIP, a.DstIP, icmp))
+
+	return frame
+}
+
+// checksum computes the ICMPv6 checksum over the IPv6 pseudo-header and msg
+func checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To16())
+	add(dst.To16())
+	sum += uint32(len(msg))
+	sum += ProtocolICMPv6
+	add(msg)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,82 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	loopback := net.ParseIP("::1")
+	tests := []struct {
+		name string
+		src  net.IP
+		dst  net.IP
+		msg  []byte
+		want uint16
+	}{
+		// 1 + 1 + length 4 + next header 58 + 0x8000
+		{"echo request", loopback, loopback, []byte{typeEchoRequest, 0, 0, 0}, 0x7fbf},
+		// An odd trailing byte is padded with zero: 1 + 1 + 5 + 58 + 0x8000 + 0xff00
+		{"odd length", loopback, loopback, []byte{typeEchoRequest, 0, 0, 0, 0xff}, 0x80bd},
+		// Sums past 0xffff fold the carry back in
+		{"carry", net.ParseIP("ffff::"), net.ParseIP("ffff::"), []byte{0xff, 0xff}, 0xffc3},
+		{"empty", net.IPv6zero, net.IPv6zero, nil, ^uint16(ProtocolICMPv6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checksum(tt.src, tt.dst, tt.msg); got != tt.want {
+				t.Errorf("checksum() = %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvertisementChecksum(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	victim := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	for _, router := range []bool{false, true} {
+		a := NewAdvertisement(mac, victim, net.ParseIP("fe80::1"), mac, router)
+		frame, err := a.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal() = %v", err)
+		}
+		if len(frame) != FrameLen {
+			t.Fatalf("len(frame) = %d, want %d", len(frame), FrameLen)
+		}
+
+		// A message carrying its checksum sums to zero
+		icmp := frame[HeaderLen+IPv6HeaderLen:]
+		if got := checksum(a.SrcIP, a.DstIP, icmp); got != 0 {
+			t.Errorf("router=%v: checksum over the sent message = %#04x, want 0", router, got)
+		}
+		if got := binary.BigEndian.Uint32(icmp[4:8]); Flags(got)&FlagRouter != 0 != router {
+			t.Errorf("router=%v: flags = %#08x", router, got)
+		}
+	}
+}
+
+func TestAdvertisementValidate(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	tests := []struct {
+		name   string
+		modify func(a *Advertisement)
+		err    error
+	}{
+		{"valid", func(a *Advertisement) {}, nil},
+		{"short MAC", func(a *Advertisement) { a.EthDst = mac[:5] }, ErrInvalidMAC},
+		{"missing target MAC", func(a *Advertisement) { a.TargetHardwareAddr = nil }, ErrInvalidMAC},
+		{"IPv4 target", func(a *Advertisement) { a.Target = net.ParseIP("192.168.1.1") }, ErrInvalidIP},
+		{"4-byte source", func(a *Advertisement) { a.SrcIP = net.IPv4(192, 168, 1, 1).To4() }, ErrInvalidIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdvertisement(mac, mac, net.ParseIP("fe80::1"), mac, false)
+			tt.modify(a)
+			if err := a.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("Validate() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
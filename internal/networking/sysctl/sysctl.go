@@ -0,0 +1,176 @@
+// Package sysctl reads and writes the kernel settings slayer depends on.
+package sysctl
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const procSys = "/proc/sys"
+
+// Setting is a kernel parameter and the value slayer needs. Keys are paths
+// relative to /proc/sys so interface names containing dots (eth0.100) work.
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// Name returns the dotted sysctl name, e.g. net.ipv4.ip_forward.
+func (s Setting) Name() string {
+	return strings.ReplaceAll(s.Key, "/", ".")
+}
+
+// Get reads a kernel parameter.
+func Get(key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procSys, key))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Set writes a kernel parameter.
+func Set(key, value string) error {
+	if err := os.WriteFile(filepath.Join(procSys, key), []byte(value+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// Manager enables the settings needed for forwarding victims' traffic and
+// puts the original values back on exit.
+type Manager struct {
+	iface    string
+	bridge   bool // iface is a bridge shaped inline, see NewBridgeManager
+	mu       sync.Mutex
+	original map[string]string // key -> value before slayer changed it
+}
+
+// NewManager returns a Manager for the given interface.
+func NewManager(iface string) *Manager {
+	return &Manager{
+		iface:    iface,
+		original: make(map[string]string),
+	}
+}
+
+// NewBridgeManager returns a Manager for a bridge slayer shapes inline. No
+// routing is involved, but bridged frames must go through iptables to be
+// marked, which needs br_netfilter loaded.
+func NewBridgeManager(bridge string) *Manager {
+	m := NewManager(bridge)
+	m.bridge = true
+	return m
+}
+
+// Required lists the settings slayer needs while redirecting traffic:
+// forwarding on, and no ICMP redirects telling victims about the real gateway.
+// With IPv6 enabled, IPv6 is forwarded too. The kernel only forwards IPv6 when
+// it is on for all interfaces, which stops router advertisements from being
+// accepted anywhere, so they are accepted regardless of forwarding on the
+// interface and on every other one that accepted them. A bridge only needs
+// bridged traffic passed to iptables.
+func (m *Manager) Required() []Setting {
+	if m.bridge {
+		return []Setting{
+			{Key: "net/bridge/bridge-nf-call-iptables", Value: "1"},
+			{Key: "net/bridge/bridge-nf-call-ip6tables", Value: "1"},
+		}
+	}
+	settings := []Setting{
+		{Key: "net/ipv4/ip_forward", Value: "1"},
+		{Key: "net/ipv4/conf/all/send_redirects", Value: "0"},
+		{Key: "net/ipv4/conf/" + m.iface + "/send_redirects", Value: "0"},
+	}
+	if _, err := os.Stat(filepath.Join(procSys, "net/ipv6/conf", m.iface)); err == nil {
+		for _, name := range m.acceptingRA() {
+			settings = append(settings, Setting{Key: "net/ipv6/conf/" + name + "/accept_ra", Value: "2"})
+		}
+		settings = append(settings, Setting{Key: "net/ipv6/conf/all/forwarding", Value: "1"})
+	}
+	return settings
+}
+
+// acceptingRA returns the interface and the others, including the default
+// for new ones, that accept router advertisements only while not forwarding
+func (m *Manager) acceptingRA() []string {
+	names := []string{m.iface}
+	entries, err := os.ReadDir(filepath.Join(procSys, "net/ipv6/conf"))
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == m.iface || name == "all" || name == "lo" {
+			continue
+		}
+		if value, err := Get("net/ipv6/conf/" + name + "/accept_ra"); err == nil && value == "1" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Check returns the required settings whose current value differs.
+func (m *Manager) Check() ([]Setting, error) {
+	var pending []Setting
+	for _, setting := range m.Required() {
+		current, err := Get(setting.Key)
+		if err != nil {
+			return nil, err
+		}
+		if current != setting.Value {
+			pending = append(pending, setting)
+		}
+	}
+	return pending, nil
+}
+
+// Enable applies the required settings, remembering the original values.
+// Calling it again is a no-op for settings that are already applied.
+func (m *Manager) Enable() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, setting := range m.Required() {
+		current, err := Get(setting.Key)
+		if err != nil {
+			return err
+		}
+		if current == setting.Value {
+			continue
+		}
+		if err := Set(setting.Key, setting.Value); err != nil {
+			return err
+		}
+		if _, recorded := m.original[setting.Key]; !recorded {
+			m.original[setting.Key] = current
+		}
+		log.Printf("Set %s = %s (was %s)", setting.Name(), setting.Value, current)
+	}
+	return nil
+}
+
+// Restore puts back every value changed by Enable.
+func (m *Manager) Restore() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed []string
+	for key, value := range m.original {
+		if err := Set(key, value); err != nil {
+			failed = append(failed, key)
+			continue
+		}
+		log.Printf("Restored %s = %s", strings.ReplaceAll(key, "/", "."), value)
+		delete(m.original, key)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package vlan handles 802.1Q tagged frames and VLAN sub-interfaces.
+package vlan
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// 802.1Q header layout
+const (
+	TPID      = 0x8100
+	HeaderLen = 4
+	MaxID     = 4094
+)
+
+// OOBSize is the control buffer size needed by Recv
+const OOBSize = 64
+
+const (
+	packetAuxData  = 8    // PACKET_AUXDATA socket option
+	tpStatusVLAN   = 0x10 // TP_STATUS_VLAN_VALID
+	auxDataVLANOff = 16   // offset of tp_vlan_tci in struct tpacket_auxdata
+)
+
+// Device is a VLAN sub-interface.
+type Device struct {
+	Name   string
+	Parent string
+	ID     uint16
+}
+
+// Validate checks that id is a usable VLAN ID.
+func Validate(id int) error {
+	if id < 1 || id > MaxID {
+		return fmt.Errorf("invalid VLAN ID %d (expected 1-%d)", id, MaxID)
+	}
+	return nil
+}
+
+// Tag returns a copy of an Ethernet frame with an 802.1Q header for id
+// inserted after the MAC addresses. An id of zero returns frame unchanged.
+func Tag(frame []byte, id uint16) []byte {
+	if id == 0 || len(frame) < 12 {
+		return frame
+	}
+	tagged := make([]byte, len(frame)+HeaderLen)
+	copy(tagged, frame[:12])
+	binary.BigEndian.PutUint16(tagged[12:14], TPID)
+	binary.BigEndian.PutUint16(tagged[14:16], id&0x0fff)
+	copy(tagged[16:], frame[12:])
+	return tagged
+}
+
+// IsTagged reports whether frame carries an in-band 802.1Q header.
+func IsTagged(frame []byte) bool {
+	return len(frame) >= 12+HeaderLen && binary.BigEndian.Uint16(frame[12:14]) == TPID
+}
+
+// Untag removes an in-band 802.1Q header, returning a copy of the untagged
+// frame and the VLAN ID. Untagged frames are returned as is with ID zero.
+func Untag(frame []byte) ([]byte, uint16) {
+	if !IsTagged(frame) {
+		return frame, 0
+	}
+	id := binary.BigEndian.Uint16(frame[14:16]) & 0x0fff
+	untagged := make([]byte, len(frame)-HeaderLen)
+	copy(untagged, frame[:12])
+	copy(untagged[12:], frame[12+HeaderLen:])
+	return untagged, id
+}
+
+// EnableAuxData asks the kernel to report VLAN tags it stripped from frames
+// received on a packet socket. Needed with Recv.
+func EnableAuxData(fd int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetAuxData, 1)
+}
+
+// Recv reads one frame from a packet socket with aux data enabled. The frame
+// is returned untagged along with its VLAN ID, whether the tag was stripped
+// by the NIC or still in-band, and the link-layer packet type.
+func Recv(fd int, buf, oob []byte) (frame []byte, id uint16, pkttype uint8, err error) {
+	n, oobn, _, from, err := syscall.Recvmsg(fd, buf, oob, 0)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if ll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		pkttype = ll.Pkttype
+	}
+
+	frame = buf[:n]
+	if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for _, msg := range msgs {
+			if msg.Header.Level != syscall.SOL_PACKET || msg.Header.Type != packetAuxData || len(msg.Data) < auxDataVLANOff+2 {
+				continue
+			}
+			status := *(*uint32)(unsafe.Pointer(&msg.Data[0]))
+			if status&tpStatusVLAN != 0 {
+				id = *(*uint16)(unsafe.Pointer(&msg.Data[auxDataVLANOff])) & 0x0fff
+			}
+		}
+	}
+	if id == 0 {
+		frame, id = Untag(frame)
+	}
+	return frame, id, pkttype, nil
+}
+
+// Devices lists the VLAN sub-interfaces on the system.
+func Devices() ([]Device, error) {
+	out, err := exec.Command("ip", "-d", "-j", "link", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VLAN devices: %v", err)
+	}
+
+	var links []struct {
+		Name     string `json:"ifname"`
+		Link     string `json:"link"`
+		LinkInfo struct {
+			Kind string `json:"info_kind"`
+			Data struct {
+				ID uint16 `json:"id"`
+			} `json:"info_data"`
+		} `json:"linkinfo"`
+	}
+	if len(strings.TrimSpace(string(out))) > 0 {
+		if err := json.Unmarshal(out, &links); err != nil {
+			return nil, fmt.Errorf("failed to parse VLAN devices: %v", err)
+		}
+	}
+
+	var devices []Device
+	for _, link := range links {
+		if link.LinkInfo.Kind != "vlan" {
+			continue
+		}
+		devices = append(devices, Device{Name: link.Name, Parent: link.Link, ID: link.LinkInfo.Data.ID})
+	}
+	return devices, nil
+}
+
+// Lookup returns the VLAN device named name, if it is one.
+func Lookup(name string) (Device, bool, error) {
+	devices, err := Devices()
+	if err != nil {
+		return Device{}, false, err
+	}
+	for _, device := range devices {
+		if device.Name == name {
+			return device, true, nil
+		}
+	}
+	return Device{}, false, nil
+}
+
+// Find returns the VLAN device for id on parent, if one exists.
+func Find(parent string, id uint16) (Device, bool, error) {
+	devices, err := Devices()
+	if err != nil {
+		return Device{}, false, err
+	}
+	for _, device := range devices {
+		if device.Parent == parent && device.ID == id {
+			return device, true, nil
+		}
+	}
+	return Device{}, false, nil
+}
@@ -0,0 +1,97 @@
+package vlan
+
+import (
+	"bytes"
+	"testing"
+)
+
+// frame is an untagged Ethernet frame carrying an ARP payload stub
+var frame = []byte{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // destination
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // source
+	0x08, 0x06, // EtherType
+	0xde, 0xad, 0xbe, 0xef, // payload
+}
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		id    uint16
+		want  []byte
+	}{
+		{"untagged", frame, 0, frame},
+		{"VLAN 1", frame, 1, append(append(append([]byte(nil), frame[:12]...), 0x81, 0x00, 0x00, 0x01), frame[12:]...)},
+		{"VLAN 4094", frame, 4094, append(append(append([]byte(nil), frame[:12]...), 0x81, 0x00, 0x0f, 0xfe), frame[12:]...)},
+		{"priority bits dropped", frame, 0xf02a, append(append(append([]byte(nil), frame[:12]...), 0x81, 0x00, 0x00, 0x2a), frame[12:]...)},
+		{"too short", frame[:11], 42, frame[:11]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]byte(nil), tt.frame...)
+			got := Tag(tt.frame, tt.id)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Tag() =\n% x\nwant\n% x", got, tt.want)
+			}
+			if !bytes.Equal(tt.frame, original) {
+				t.Errorf("Tag() modified its input")
+			}
+		})
+	}
+}
+
+func TestUntag(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  []byte
+		want   []byte
+		id     uint16
+		tagged bool
+	}{
+		{"untagged", frame, frame, 0, false},
+		{"tagged", Tag(frame, 42), frame, 42, true},
+		{"priority and DEI set", append(append(append([]byte(nil), frame[:12]...), 0x81, 0x00, 0xf0, 0x2a), frame[12:]...), frame, 42, true},
+		{"other EtherType", append(append(append([]byte(nil), frame[:12]...), 0x88, 0xa8, 0x00, 0x2a), frame[12:]...),
+			append(append(append([]byte(nil), frame[:12]...), 0x88, 0xa8, 0x00, 0x2a), frame[12:]...), 0, false},
+		{"too short for a tag", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0x00, 0x00}, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0x00, 0x00}, 0, false},
+		{"empty", nil, nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTagged(tt.frame); got != tt.tagged {
+				t.Errorf("IsTagged() = %v, want %v", got, tt.tagged)
+			}
+			got, id := Untag(tt.frame)
+			if !bytes.Equal(got, tt.want) || id != tt.id {
+				t.Errorf("Untag() = % x, %d, want % x, %d", got, id, tt.want, tt.id)
+			}
+		})
+	}
+}
+
+func TestTagUntagRoundTrip(t *testing.T) {
+	for _, id := range []uint16{1, 2, 100, 1000, 4094} {
+		got, gotID := Untag(Tag(frame, id))
+		if !bytes.Equal(got, frame) || gotID != id {
+			t.Errorf("Untag(Tag(frame, %d)) = % x, %d", id, got, gotID)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		id    int
+		valid bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{4094, true},
+		{4095, false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.id); (err == nil) != tt.valid {
+			t.Errorf("Validate(%d) = %v, want valid %v", tt.id, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package workload finds local containers and VMs from the veth and tap
+// interfaces connecting them to this machine.
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Kinds of interfaces a workload can sit behind
+const (
+	KindVeth = "veth"
+	KindTap  = "tap"
+)
+
+// Workload is a container or VM and the interface it is reached through.
+type Workload struct {
+	Device string           // host side of the veth pair, or the VM's tap
+	Kind   string           // KindVeth or KindTap
+	Bridge string           // bridge Device is a port of, empty when routed
+	MAC    net.HardwareAddr // the workload's own MAC
+	IPs    []net.IP         // addresses the workload was seen using
+}
+
+// IPv4 returns the workload's first IPv4 address, or nil.
+func (w Workload) IPv4() net.IP {
+	for _, ip := range w.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return nil
+}
+
+type link struct {
+	Name     string `json:"ifname"`
+	Master   string `json:"master"`
+	LinkInfo struct {
+		Kind string `json:"info_kind"`
+		Data struct {
+			Type string `json:"type"`
+		} `json:"info_data"`
+	} `json:"linkinfo"`
+}
+
+type neighbor struct {
+	Dst    string `json:"dst"`
+	LLAddr string `json:"lladdr"`
+}
+
+type fdbEntry struct {
+	MAC   string   `json:"mac"`
+	Flags []string `json:"flags"`
+	State string   `json:"state"`
+}
+
+// Discover lists the workloads behind every veth and tap interface. Ports
+// of a bridge are matched to addresses through the MACs the bridge learned
+// on them; routed interfaces through their own neighbor table. Interfaces
+// with an address of their own are endpoints of this machine and skipped.
+// Addresses come from the neighbor tables, so they are only known once a
+// workload has talked to this machine.
+func Discover() ([]Workload, error) {
+	var links []link
+	if err := ipJSON(&links, "ip", "-d", "-j", "link", "show"); err != nil {
+		return nil, err
+	}
+
+	var workloads []Workload
+	for _, l := range links {
+		kind := kindOf(l)
+		if kind == "" || hasAddress(l.Name) {
+			continue
+		}
+
+		var found []Workload
+		var err error
+		if l.Master != "" {
+			found, err = bridged(l.Name, l.Master)
+		} else {
+			found, err = routed(l.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range found {
+			w.Kind = kind
+			workloads = append(workloads, w)
+		}
+	}
+	return workloads, nil
+}
+
+// kindOf returns the workload kind of a link, or "" if it is neither
+func kindOf(l link) string {
+	switch {
+	case l.LinkInfo.Kind == "veth":
+		return KindVeth
+	case l.LinkInfo.Kind == "tun" && l.LinkInfo.Data.Type == "tap":
+		return KindTap
+	}
+	return ""
+}
+
+// bridged returns the workloads behind port, a port of bridge: every MAC
+// the bridge learned there, with the addresses it uses on the bridge.
+func bridged(port, bridge string) ([]Workload, error) {
+	var entries []fdbEntry
+	if err := ipJSON(&entries, "bridge", "-j", "fdb", "show", "br", bridge, "brport", port); err != nil {
+		return nil, err
+	}
+	neighbors, err := neighbors(bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []Workload
+	for _, entry := range entries {
+		// Permanent entries are the port's own MAC and multicast groups
+		if entry.State == "permanent" || slices.Contains(entry.Flags, "self") {
+			continue
+		}
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			continue
+		}
+		workloads = append(workloads, Workload{Device: port, Bridge: bridge, MAC: mac, IPs: neighbors[mac.String()]})
+	}
+	return workloads, nil
+}
+
+// routed returns the workloads reached directly through dev
+func routed(dev string) ([]Workload, error) {
+	neighbors, err := neighbors(dev)
+	if err != nil {
+		return nil, err
+	}
+	var workloads []Workload
+	for mac, ips := range neighbors {
+		hw, _ := net.ParseMAC(mac)
+		workloads = append(workloads, Workload{Device: dev, MAC: hw, IPs: ips})
+	}
+	return workloads, nil
+}
+
+// neighbors returns the IPv4 and IPv6 neighbor table of dev keyed by MAC
+func neighbors(dev string) (map[string][]net.IP, error) {
+	byMAC := make(map[string][]net.IP)
+	for _, family := range []string{"-4", "-6"} {
+		var entries []neighbor
+		if err := ipJSON(&entries, "ip", family, "-j", "neigh", "show", "dev", dev); err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ip := net.ParseIP(entry.Dst)
+			mac, err := net.ParseMAC(entry.LLAddr)
+			if ip == nil || err != nil {
+				continue // incomplete or failed entries have no lladdr
+			}
+			byMAC[mac.String()] = append(byMAC[mac.String()], ip)
+		}
+	}
+	return byMAC, nil
+}
+
+// hasAddress reports whether the named interface has an IPv4 or global IPv6
+// address, making it an endpoint rather than a workload's port
+func hasAddress(name string) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false
+	}
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// ipJSON runs an iproute2 command with JSON output and decodes it into v
+func ipJSON(v any, name string, args ...string) error {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return fmt.Errorf("failed to run %s %s: %v", name, strings.Join(args, " "), err)
+	}
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("failed to parse %s output: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package profile manages named limit presets shared across hosts.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/prabalesh/slayer/internal/limiter"
+)
+
+// DefaultPath is where profiles are loaded from and saved to by default
+const DefaultPath = "/etc/slayer/profiles.json"
+
+// Profile is a named limiter spec.
+type Profile struct {
+	Name string `json:"name"`
+	limiter.Spec
+}
+
+// Builtin profiles used when no config file exists yet
+var builtin = []Profile{
+	{Name: "dialup", Spec: limiter.Spec{UploadRate: "56kbit", DownloadRate: "56kbit", Delay: "150ms", Jitter: "20ms"}},
+	{Name: "sd-video", Spec: limiter.Spec{UploadRate: "1mbit", DownloadRate: "3mbit", LeafQdisc: "fq_codel"}},
+	{Name: "meeting-safe", Spec: limiter.Spec{UploadRate: "2mbit", DownloadRate: "4mbit", Ceil: "6mbit", LeafQdisc: "fq_codel"}},
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Manager holds all known profiles and persists them to a JSON file.
+type Manager struct {
+	path     string
+	profiles map[string]Profile
+	mu       sync.Mutex
+}
+
+// NewManager loads profiles from path. A missing file yields the builtin set.
+func NewManager(path string) (*Manager, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+	m := &Manager{
+		path:     path,
+		profiles: make(map[string]Profile),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		for _, p := range builtin {
+			m.profiles[p.Name] = p
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles from %s: %w", path, err)
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles in %s: %w", path, err)
+	}
+	for _, p := range profiles {
+		if err := validate(p); err != nil {
+			return nil, fmt.Errorf("invalid profile in %s: %w", path, err)
+		}
+		m.profiles[p.Name] = p
+	}
+	return m, nil
+}
+
+// Path returns the file profiles are saved to.
+func (m *Manager) Path() string {
+	return m.path
+}
+
+// Get returns the profile with the given name.
+func (m *Manager) Get(name string) (Profile, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, exists := m.profiles[name]
+	return p, exists
+}
+
+// List returns all profiles sorted by name.
+func (m *Manager) List() []Profile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	profiles := make([]Profile, 0, len(m.profiles))
+	for _, p := range m.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// Set creates or replaces a profile and saves the config file.
+func (m *Manager) Set(p Profile) error {
+	if err := validate(p); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.profiles[p.Name] = p
+	return m.save()
+}
+
+// Delete removes a profile and saves the config file.
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.profiles[name]; !exists {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	delete(m.profiles, name)
+	return m.save()
+}
+
+// save writes all profiles to the config file. Must be called with mu held.
+func (m *Manager) save() error {
+	profiles := make([]Profile, 0, len(m.profiles))
+	for _, p := range m.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(m.path), err)
+	}
+	if err := os.WriteFile(m.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save profiles to %s: %w", m.path, err)
+	}
+	return nil
+}
+
+func validate(p Profile) error {
+	if !nameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name '%s'", p.Name)
+	}
+	if !p.HasRates() {
+		return fmt.Errorf("profile %s must set an upload or download rate", p.Name)
+	}
+	if err := p.Spec.Validate(); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	return nil
+}
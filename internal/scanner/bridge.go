@@ -0,0 +1,41 @@
+package scanner
+
+import (
+	"net"
+	"time"
+
+	"github.com/prabalesh/slayer/internal/networking/bridge"
+	"github.com/prabalesh/slayer/internal/store"
+)
+
+// BridgeListenTime is how long a bridge scan watches traffic for hosts
+const BridgeListenTime = 10 * time.Second
+
+// BridgeScanner finds hosts behind the LAN port of a bridge from the traffic
+// they send. Nothing is probed, so the bridge needs no address.
+type BridgeScanner struct {
+	port  *net.Interface
+	wait  time.Duration
+	store *store.Store
+}
+
+func NewBridgeScanner(s *store.Store) *BridgeScanner {
+	return &BridgeScanner{
+		port:  s.Iface,
+		wait:  BridgeListenTime,
+		store: s,
+	}
+}
+
+// Scan listens on the LAN port and adds every host seen to the store.
+func (b *BridgeScanner) Scan() error {
+	neighbors, err := bridge.Discover(b.port, b.wait)
+	if err != nil {
+		return err
+	}
+
+	for _, neighbor := range neighbors {
+		addHost(b.store, &store.Host{IP: neighbor.IP, MAC: neighbor.MAC})
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package scanner
+
+import (
+	"github.com/prabalesh/slayer/internal/networking/workload"
+	"github.com/prabalesh/slayer/internal/store"
+)
+
+// LocalScanner finds containers and VMs on this machine from their veth and
+// tap interfaces. Nothing is sent, the kernel's tables already know them.
+type LocalScanner struct {
+	store *store.Store
+}
+
+func NewLocalScanner(s *store.Store) *LocalScanner {
+	return &LocalScanner{store: s}
+}
+
+// Scan adds every workload with an IPv4 address to the store. It returns how
+// many workloads were skipped because their address is not known yet.
+func (l *LocalScanner) Scan() (int, error) {
+	workloads, err := workload.Discover()
+	if err != nil {
+		return 0, err
+	}
+
+	skipped := 0
+	for _, w := range workloads {
+		ip := w.IPv4()
+		if ip == nil {
+			skipped++
+			continue
+		}
+
+		host := &store.Host{
+			IP:     ip,
+			MAC:    w.MAC,
+			Device: w.Device,
+		}
+		for _, addr := range w.IPs {
+			if addr.To4() == nil {
+				host.IPv6 = append(host.IPv6, addr)
+			}
+		}
+		addHost(l.store, host)
+	}
+	return skipped, nil
+}
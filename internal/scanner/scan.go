@@ -1,192 +1,229 @@
 package scanner
 
 import (
+	"bytes"
+	"fmt"
 	"net"
-	"net/netip"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/mdlayher/arp"
+	"github.com/prabalesh/slayer/internal/networking/arp"
 	"github.com/prabalesh/slayer/internal/store"
 )
 
-type Job func()
-
-type Pool struct {
-	workerQueue chan Job
-	wg          sync.WaitGroup
-}
-
-func NewPool(workerCount int) *Pool {
-	pool := &Pool{workerQueue: make(chan Job)}
-	pool.wg.Add(workerCount)
-	for i := 0; i < workerCount; i++ {
-		go func() {
-			defer pool.wg.Done()
-			for job := range pool.workerQueue {
-				job()
-			}
-		}()
-	}
-	return pool
-}
-
-func (p *Pool) AddJob(job Job) {
-	p.workerQueue <- job
-}
-
-func (p *Pool) Wait() {
-	close(p.workerQueue)
-	p.wg.Wait()
-}
+// Sweep defaults, overridden by store.ScanOptions
+const (
+	DefaultPPS     = 1000             // ARP requests sent per second
+	DefaultRetries = 2                // extra rounds for addresses that did not answer
+	DefaultTimeout = 90 * time.Second // ceiling on a whole scan
+	ReplyWait      = time.Second      // how long replies are awaited after each round
+	readTimeout    = 100 * time.Millisecond
+)
 
-// FastConsistentArpScanner - Fast but consistent results
+// ArpScanner sweeps a network with ARP requests sent from one raw socket at a
+// steady rate, while a single receive loop collects the replies. Addresses
+// that did not answer are asked again in later rounds, so a scan takes about
+// the time to send every request plus ReplyWait per round, whatever the size
+// of the network.
 type ArpScanner struct {
-	idCounter  int64
-	iface      *net.Interface
-	timeout    time.Duration
-	maxWorkers int
-	store      *store.Store
+	iface    *net.Interface
+	vlan     uint16 // tag requests for this VLAN of iface
+	sourceIP net.IP // our address on the scanned network
+	pps      int
+	retries  int
+	timeout  time.Duration
+	store    *store.Store
 }
 
 func NewArpScanner(s *store.Store) *ArpScanner {
-	return &ArpScanner{
-		iface:      s.Iface,
-		timeout:    2 * time.Second, // Back to your original 2s
-		maxWorkers: 50,              // Back to your original 50
-		store:      s,
+	a := &ArpScanner{
+		iface:   s.Iface,
+		vlan:    s.VLAN,
+		pps:     s.Scan.PPS,
+		retries: s.Scan.Retries,
+		timeout: s.Scan.Timeout,
+		store:   s,
+	}
+	if a.pps <= 0 {
+		a.pps = DefaultPPS
+	}
+	if a.retries < 0 {
+		a.retries = 0
 	}
+	if a.timeout <= 0 {
+		a.timeout = DefaultTimeout
+	}
+	return a
+}
+
+// Rate returns the number of requests sent per second.
+func (a *ArpScanner) Rate() int {
+	return a.pps
 }
 
-// Simple scan with just one retry for failed IPs
-func (a *ArpScanner) Scan(ips []net.IP) {
+// Scan asks every address in ips for its MAC and adds the hosts answering to
+// the store as their replies arrive. It stops early once all have answered
+// and gives up on the rest when the timeout expires.
+func (a *ArpScanner) Scan(ips []net.IP) error {
 	if a.iface == nil || len(ips) == 0 {
-		return
+		return nil
 	}
 
-	var hostsMutex sync.Mutex
-	var foundIPs sync.Map // Thread-safe map to track found IPs
+	// On a trunk the address lives on the VLAN device, not on iface
+	sourceIP, err := arp.InterfaceIPv4(a.store.ShapeIface)
+	if err != nil {
+		return err
+	}
+	a.sourceIP = sourceIP
 
-	// Round 1: Main scan
-	pool := NewPool(a.maxWorkers)
-	for _, ip := range ips {
-		currentIP := ip
-		job := func() {
-			host := a.scanSingleIP(currentIP)
-			if host != nil {
-				foundIPs.Store(currentIP.String(), true)
-				hostsMutex.Lock()
-				a.store.AddHost(host)
-				hostsMutex.Unlock()
-			}
-		}
-		pool.AddJob(job)
+	listener, err := arp.NewVLANListener(a.iface, a.vlan, readTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", a.iface.Name, err)
+	}
+	defer listener.Close()
+	sender, err := arp.NewVLANSender(a.iface, a.vlan)
+	if err != nil {
+		return fmt.Errorf("failed to open socket on %s: %v", a.iface.Name, err)
 	}
-	pool.Wait()
+	defer sender.Close()
 
-	// Quick retry for missed IPs (only takes a few seconds)
-	var missedIPs []net.IP
+	// Addresses still waiting for a reply, our own never answers
+	var mu sync.Mutex
+	pending := make(map[[4]byte]bool, len(ips))
 	for _, ip := range ips {
-		if _, found := foundIPs.Load(ip.String()); !found {
-			missedIPs = append(missedIPs, ip)
+		if ip4 := ip.To4(); ip4 != nil && !ip4.Equal(sourceIP) {
+			pending[[4]byte(ip4)] = true
 		}
 	}
-
-	// Only retry if we missed some and it's reasonable amount
-	if len(missedIPs) > 0 && len(missedIPs) < len(ips)/2 {
-		time.Sleep(200 * time.Millisecond) // Short pause
-
-		retryPool := NewPool(a.maxWorkers)
-		for _, ip := range missedIPs {
-			currentIP := ip
-			job := func() {
-				host := a.scanSingleIPWithRetry(currentIP)
-				if host != nil {
-					hostsMutex.Lock()
-					a.store.AddHost(host)
-					hostsMutex.Unlock()
-				}
+	remaining := func() []net.IP {
+		mu.Lock()
+		defer mu.Unlock()
+		left := make([]net.IP, 0, len(pending))
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && pending[[4]byte(ip4)] {
+				left = append(left, ip4)
 			}
-			retryPool.AddJob(job)
 		}
-		retryPool.Wait()
-	}
-}
-
-// Your original scan method (proven to work)
-func (a *ArpScanner) scanSingleIP(ip net.IP) *store.Host {
-	conn, err := arp.Dial(a.iface)
-	if err != nil {
-		return nil
+		return left
 	}
-	defer conn.Close()
-
-	deadline := time.Now().Add(a.timeout)
-	conn.SetDeadline(deadline)
 
-	ipAddr, ok := netip.AddrFromSlice(ip)
-	if !ok {
-		return nil
-	}
+	done := make(chan struct{})     // all addresses answered
+	stop := make(chan struct{})     // sending is over, stop receiving
+	received := make(chan error, 1) // result of the receive loop
+	go func() {
+		received <- a.receive(listener, &mu, pending, done, stop)
+	}()
 
-	mac, err := conn.Resolve(ipAddr)
-	if err != nil {
-		return nil
+	deadline := time.After(a.timeout)
+	var sendErr error
+rounds:
+	for round := 0; round <= a.retries; round++ {
+		left := remaining()
+		if len(left) == 0 {
+			break
+		}
+		if sendErr = a.sweep(sender, left, done, deadline); sendErr != nil {
+			break
+		}
+		select {
+		case <-time.After(ReplyWait):
+		case <-done:
+			break rounds
+		case <-deadline:
+			break rounds
+		}
 	}
+	close(stop)
 
-	id := atomic.AddInt64(&a.idCounter, 1)
-	host := &store.Host{
-		ID:  id,
-		IP:  ip,
-		MAC: mac,
+	if err := <-received; err != nil {
+		return err
 	}
+	return sendErr
+}
 
-	// Background hostname lookup
-	go func() {
-		if names, err := net.LookupAddr(ip.String()); err == nil && len(names) > 0 {
-			host.Hostname = names[0]
+// sweep sends one request to each of ips, spaced to stay under the rate. It
+// returns early, without error, when done is closed or the deadline passes.
+func (a *ArpScanner) sweep(sender *arp.Sender, ips []net.IP, done <-chan struct{}, deadline <-chan time.Time) error {
+	gap := time.Second / time.Duration(a.pps)
+	start := time.Now()
+	for i, ip := range ips {
+		// Sleep only once a few milliseconds of sends are due, timers are
+		// too coarse to space single frames at high rates
+		if ahead := time.Until(start.Add(gap * time.Duration(i))); ahead > time.Millisecond {
+			select {
+			case <-time.After(ahead):
+			case <-done:
+				return nil
+			case <-deadline:
+				return nil
+			}
 		}
-	}()
 
-	return host
+		request, err := arp.NewRequest(a.iface.HardwareAddr, a.sourceIP, ip).Frame()
+		if err != nil {
+			return err
+		}
+		if err := sender.Send(request.Packet, request.Dst); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Single retry with slightly longer timeout
-func (a *ArpScanner) scanSingleIPWithRetry(ip net.IP) *store.Host {
-	conn, err := arp.Dial(a.iface)
-	if err != nil {
-		return nil
-	}
-	defer conn.Close()
+// receive reads replies until stop is closed, adding each pending address
+// that answers to the store. It closes done once none are pending.
+func (a *ArpScanner) receive(listener *arp.Listener, mu *sync.Mutex, pending map[[4]byte]bool, done, stop chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
 
-	// Slightly longer timeout for retry
-	deadline := time.Now().Add(a.timeout + 500*time.Millisecond)
-	conn.SetDeadline(deadline)
+		p, err := listener.Read()
+		if err != nil {
+			return err
+		}
+		if p == nil || p.Operation != arp.OperationReply ||
+			!(bytes.Equal(p.TargetHardwareAddr, a.iface.HardwareAddr) || p.IsGratuitous()) {
+			continue
+		}
+		ip4 := p.SenderIP.To4()
+		if ip4 == nil {
+			continue
+		}
 
-	ipAddr, ok := netip.AddrFromSlice(ip)
-	if !ok {
-		return nil
-	}
+		mu.Lock()
+		found := pending[[4]byte(ip4)]
+		delete(pending, [4]byte(ip4))
+		empty := found && len(pending) == 0
+		mu.Unlock()
+		if !found {
+			continue // a late duplicate or a reply to someone else
+		}
 
-	mac, err := conn.Resolve(ipAddr)
-	if err != nil {
-		return nil
+		addHost(a.store, &store.Host{IP: ip4, MAC: p.SenderHardwareAddr})
+		if empty {
+			close(done)
+		}
 	}
+}
 
-	id := atomic.AddInt64(&a.idCounter, 1)
-	host := &store.Host{
-		ID:  id,
-		IP:  ip,
-		MAC: mac,
+// addHost adds a host found by a scan to s and resolves its name in
+// the background
+func addHost(s *store.Store, found *store.Host) {
+	host, added := s.AddHost(found)
+	if !added {
+		return
 	}
-
-	// Background hostname lookup
 	go func() {
-		if names, err := net.LookupAddr(ip.String()); err == nil && len(names) > 0 {
-			host.Hostname = names[0]
+		names, err := net.LookupAddr(host.IP.String())
+		if err != nil || len(names) == 0 {
+			return
 		}
+		s.UpdateHost(host.ID, func(h *store.Host) error {
+			h.Hostname = names[0]
+			return nil
+		})
 	}()
-
-	return host
 }
@@ -0,0 +1,95 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prabalesh/slayer/internal/spoof"
+	"github.com/prabalesh/slayer/internal/store"
+)
+
+func (s *ShellSession) ConnLimit(args []string) {
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: connlimit <host_id> <max|none>")
+		fmt.Println("💡 Example: connlimit 4 100")
+		fmt.Println("💡 Use 'none' to remove the connection limit")
+		return
+	}
+
+	// Parse host ID
+	hostId, err := strconv.Atoi(args[0])
+	if err != nil || hostId < 0 {
+		fmt.Printf("❌ Invalid host ID '%s': must be a positive number\n", args[0])
+		return
+	}
+
+	// Get target host
+	targetHost, exists := s.store.GetHost(int64(hostId))
+	if !exists {
+		fmt.Printf("❌ Host with ID %d not found\n", hostId)
+		fmt.Println("💡 Use 'list' command to see available hosts")
+		return
+	}
+	if targetHost.Self {
+		fmt.Println("❌ Connection limits apply to forwarded traffic, not to this machine's own")
+		return
+	}
+
+	maxArg := strings.TrimSpace(args[1])
+	if maxArg == "none" {
+		if targetHost.ConnLimit == 0 {
+			fmt.Printf("⚠️  Host %s (%s) has no connection limit\n", targetHost.IP, targetHost.Hostname)
+			return
+		}
+		err := s.store.UpdateHost(targetHost.ID, func(host *store.Host) error {
+			if err := s.store.Firewall.RemoveConnLimit(host.IP.String()); err != nil {
+				return err
+			}
+			host.ConnLimit = 0
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to remove connection limit for %s: %v\n", targetHost.IP, err)
+			return
+		}
+		s.store.SpoofManager.Release(store.OwnerConnLimit, targetHost.ID)
+		fmt.Printf("✅ Connection limit removed for %s (%s)\n", targetHost.IP, targetHost.Hostname)
+		return
+	}
+
+	max, err := strconv.Atoi(maxArg)
+	if err != nil || max <= 0 {
+		fmt.Printf("❌ Invalid connection limit '%s': must be a positive number\n", maxArg)
+		return
+	}
+
+	fmt.Printf("🎯 Target: %s (%s)\n", targetHost.IP, targetHost.Hostname)
+	fmt.Printf("🔗 Max connections: %d\n", max)
+
+	// Start ARP spoofing, poisoning only the host: its outgoing connections
+	// are what is capped
+	err = s.store.Redirect(store.OwnerConnLimit, &targetHost, spoof.Options{Direction: spoof.DirectionFor(true, false)})
+	if err != nil {
+		fmt.Printf("❌ Failed to start ARP spoofing for %s: %v\n", targetHost.IP, err)
+		return
+	}
+
+	hadLimit := targetHost.ConnLimit > 0
+	err = s.store.UpdateHost(targetHost.ID, func(host *store.Host) error {
+		hadLimit = host.ConnLimit > 0
+		if err := s.store.Firewall.LimitConnections(host.IP.String(), host.IPv6Strings(), max); err != nil {
+			return err
+		}
+		host.ConnLimit = max
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to apply connection limit: %v\n", err)
+		if !hadLimit {
+			s.store.SpoofManager.Release(store.OwnerConnLimit, targetHost.ID)
+		}
+		return
+	}
+	fmt.Printf("✅ Connection limit applied for %s (max: %d)\n", targetHost.IP, max)
+}
@@ -1,26 +1,72 @@
 package shell
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"strings"
+)
 
 func (s *ShellSession) DisplayActiveHosts() {
-	if len(s.store.Hosts) <= 0 {
+	hosts := s.store.ListHosts()
+	if len(hosts) <= 0 {
 		fmt.Println("❌ No devices online")
 		return
 	}
 
 	fmt.Println("\n📊 Active Hosts:")
-	fmt.Println("════════════════════════════════════════════════════════════════════════════════════════════════════")
-	fmt.Printf("%-4s %-15s %-18s %-30s %-8s %-10s %-10s\n", "ID", "IP Address", "MAC Address", "Hostname", "Limited", "Download", "Upload")
-	fmt.Println("────────────────────────────────────────────────────────────────────────────────────────────────────")
+	fmt.Println("═══════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("%-4s %-15s %-18s %-30s %-8s %-10s %-10s %-14s %-8s %-10s\n", "ID", "IP Address", "MAC Address", "Hostname", "Limited", "Download", "Upload", "Profile", "Priority", "Conns")
+	fmt.Println("───────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────")
 
-	for id, host := range s.store.Hosts {
+	for _, host := range hosts {
 		status := "❌"
 		if host.Limited {
 			status = "✅"
 		}
-		fmt.Printf("%-4d %-15s %-18s %-30s %-8s %-10s %-10s\n", id, host.IP, host.MAC, host.Hostname, status, host.DownloadSpeed, host.UploadSpeed)
+		priority := host.Priority
+		if priority == "" {
+			priority = "-"
+		}
+		conns := "-"
+		if host.ConnLimit > 0 {
+			count, err := s.store.Firewall.ConnCount(host.IP.String())
+			if err != nil {
+				conns = fmt.Sprintf("?/%d", host.ConnLimit)
+			} else {
+				conns = fmt.Sprintf("%d/%d", count, host.ConnLimit)
+			}
+		}
+		profileName := host.Profile
+		if profileName == "" {
+			profileName = "-"
+		}
+		fmt.Printf("%-4d %-15s %-18s %-30s %-8s %-10s %-10s %-14s %-8s %-10s\n", host.ID, host.IP, host.MAC, host.Hostname, status, host.Spec.DownloadRate, host.Spec.UploadRate, profileName, priority, conns)
+		if len(host.IPv6) > 0 {
+			fmt.Printf("%-4s ↳ IPv6: %s\n", "", joinIPs(host.IPv6))
+		}
+		if len(host.Overrides) > 0 {
+			fmt.Printf("%-4s ↳ Over profile: %s\n", "", formatOverrides(host.Overrides))
+		}
+		if host.Device != "" {
+			fmt.Printf("%-4s ↳ Device: %s\n", "", host.Device)
+		}
+		if host.UID != "" {
+			fmt.Printf("%-4s ↳ Only user: %s\n", "", host.UID)
+		}
+		if host.Cgroup != "" {
+			fmt.Printf("%-4s ↳ Only cgroup: %s\n", "", host.Cgroup)
+		}
 	}
 
-	fmt.Println("════════════════════════════════════════════════════════════════════════════════════════════════════")
-	fmt.Printf("📈 Total devices found: %d\n\n", len(s.store.Hosts))
+	fmt.Println("═══════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("📈 Total devices found: %d\n\n", len(hosts))
+}
+
+// joinIPs formats addresses as a comma-separated list
+func joinIPs(ips []net.IP) string {
+	parts := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		parts = append(parts, ip.String())
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,67 @@
+package shell
+
+import (
+	"fmt"
+
+	"github.com/prabalesh/slayer/internal/events"
+)
+
+// renderEvents prints background events above the prompt until the shell
+// closes.
+func (s *ShellSession) renderEvents() {
+	failing := make(map[int64]string)
+	for {
+		select {
+		case <-s.quit:
+			return
+		case e := <-s.store.Events.Events():
+			if msg, show := formatEvent(e, failing); show {
+				fmt.Fprintln(s.rl.Stdout(), msg)
+			}
+		}
+	}
+}
+
+// drainEvents prints whatever is still queued, used once readline is closed.
+func (s *ShellSession) drainEvents() {
+	failing := make(map[int64]string)
+	for {
+		select {
+		case e := <-s.store.Events.Events():
+			if msg, show := formatEvent(e, failing); show {
+				fmt.Println(msg)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// formatEvent renders an event. failing remembers the last error shown per
+// host so a send error repeating every refresh is only printed once.
+func formatEvent(e events.Event, failing map[int64]string) (string, bool) {
+	switch e.Kind {
+	case events.SessionStarted:
+		return fmt.Sprintf("[*] ARP spoofing active for %s (%s)", e.IP, e.Message), true
+	case events.SendFailed:
+		errText := fmt.Sprint(e.Err)
+		if failing[e.HostID] == errText {
+			return "", false
+		}
+		failing[e.HostID] = errText
+		return fmt.Sprintf("⚠️  [%s] Failed to refresh ARP for %s: %s (repeats are hidden)", e.Source, e.IP, errText), true
+	case events.Recovered:
+		delete(failing, e.HostID)
+		return fmt.Sprintf("✅ [%s] ARP refresh for %s recovered after %d failed attempts", e.Source, e.IP, e.Count), true
+	case events.SessionStopped:
+		delete(failing, e.HostID)
+		if e.Err != nil {
+			return fmt.Sprintf("❌ [%s] Spoofing %s %s: %v", e.Source, e.IP, e.Message, e.Err), true
+		}
+		return fmt.Sprintf("[*] %s", e.Message), true
+	case events.HostMoved, events.GatewayChanged:
+		return fmt.Sprintf("🔀 [%s] %s", e.Source, e.Message), true
+	default:
+		return "⚠️  " + e.String(), true
+	}
+}
@@ -3,15 +3,18 @@ package shell
 import "fmt"
 
 var commands = map[string]string{
-	"scan":    "Perform network scan to discover active hosts",
-	"list":    "Display all discovered active hosts",
-	"limit":   "Set bandwidth limits on target hosts",
-	"unlimit": "Removes bandwidth limits on target hosts",
-	"spoof":   "Perform ARP spoofing attack",
-	"help":    "Show available commands",
-	"quit":    "Exit Slayer",
-	"exit":    "Exit Slayer",
-	"clear":   "Clear the terminal screen",
+	"scan":       "Perform network scan to discover active hosts",
+	"list":       "Display all discovered active hosts",
+	"limit":      "Set bandwidth limits on target hosts",
+	"unlimit":    "Removes bandwidth limits on target hosts",
+	"prioritize": "Guarantee bandwidth to hosts under contention",
+	"connlimit":  "Cap concurrent connections of target hosts",
+	"profile":    "Manage named limit presets",
+	"spoof":      "Perform ARP spoofing attack",
+	"help":       "Show available commands",
+	"quit":       "Exit Slayer",
+	"exit":       "Exit Slayer",
+	"clear":      "Clear the terminal screen",
 }
 
 func (s *ShellSession) HandleHelp() {
@@ -20,11 +23,11 @@ func (s *ShellSession) HandleHelp() {
                         🔥 SLAYER COMMANDS 🔥
 ══════════════════════════════════════════════════════════════`)
 
-	commandOrder := []string{"scan", "list", "limit", "spoof", "clear", "help", "quit"}
+	commandOrder := []string{"scan", "list", "limit", "unlimit", "prioritize", "connlimit", "profile", "spoof", "clear", "help", "quit"}
 
 	for _, cmd := range commandOrder {
 		if desc, exists := commands[cmd]; exists {
-			fmt.Printf("║  %-8s │ %-45s ║\n", cmd, desc)
+			fmt.Printf("║  %-10s │ %-45s ║\n", cmd, desc)
 		}
 	}
 
@@ -2,67 +2,226 @@ package shell
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/spoof"
+	"github.com/prabalesh/slayer/internal/store"
 )
 
 // Modified Limit function for ShellSession
 func (s *ShellSession) Limit(args []string) {
-	if len(args) < 3 {
-		fmt.Println("❌ Usage: limit <host_id> <upload_rate|none> <download_rate|none>")
+	positional, optionArgs := splitOptions(args)
+	if len(positional) != 1 && len(positional) != 3 {
+		fmt.Println("❌ Usage: limit <host_id> <upload_rate|none> <download_rate|none> [options]")
+		fmt.Println("❌        limit <host_id> profile=<name> [options]")
 		fmt.Println("💡 Example: limit 1 100kbit 500kbit")
+		fmt.Println("💡 Example: limit 4 profile=sd-video")
 		fmt.Println("💡 Use 'none' if you want to skip upload/download limit")
+		fmt.Println("💡 Options: match=ip|mac ceil=<rate> burst=<size> qdisc=<leaf> delay=<time> jitter=<time> loss=<pct>")
+		fmt.Println("💡 Use 'match=mac' to keep the limit when the host gets a new IP")
+		fmt.Println("💡 Use 'uid=<user>' or 'cgroup=<path>' on this machine (self) to shape only part of its traffic")
 		return
 	}
 
 	// Parse host ID
-	hostId, err := strconv.Atoi(args[0])
+	hostId, err := strconv.Atoi(positional[0])
 	if err != nil || hostId < 0 {
-		fmt.Printf("❌ Invalid host ID '%s': must be a positive number\n", args[0])
+		fmt.Printf("❌ Invalid host ID '%s': must be a positive number\n", positional[0])
 		return
 	}
 
-	uploadRate := strings.TrimSpace(args[1])
-	downloadRate := strings.TrimSpace(args[2])
+	options, err := parseOptions(optionArgs)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
 
-	// Validate rates
-	if uploadRate == "none" {
-		uploadRate = ""
+	// Start from the profile, then let explicit rates and options override it
+	var spec limiter.Spec
+	profileName := options["profile"]
+	if profileName != "" {
+		p, exists := s.store.Profiles.Get(profileName)
+		if !exists {
+			fmt.Printf("❌ Profile '%s' not found\n", profileName)
+			fmt.Println("💡 Use 'profile list' to see available profiles")
+			return
+		}
+		spec = p.Spec
+	} else if len(positional) != 3 {
+		fmt.Println("❌ Upload and download rates are required without a profile")
+		return
 	}
-	if downloadRate == "none" {
-		downloadRate = ""
+
+	// Remember what was set on top of the profile, so re-applying it later
+	// keeps this host's own settings
+	overrides := make(map[string]string)
+	if len(positional) == 3 {
+		overrides["upload"] = strings.TrimSpace(positional[1])
+		overrides["download"] = strings.TrimSpace(positional[2])
+	}
+	for key, value := range options {
+		if key == "profile" || key == "match" || key == "uid" || key == "cgroup" {
+			continue
+		}
+		overrides[key] = value
+	}
+	if err := applyOverrides(&spec, overrides); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	if profileName == "" || len(overrides) == 0 {
+		overrides = nil
 	}
-	if uploadRate == "" && downloadRate == "" {
+
+	// Validate rates
+	if !spec.HasRates() {
 		fmt.Println("❌ At least one of upload or download rate must be specified")
 		return
 	}
+	if err := spec.Validate(); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	uploadRate, downloadRate := spec.UploadRate, spec.DownloadRate
+
+	matchMAC := false
+	switch options["match"] {
+	case "", "ip":
+	case "mac":
+		matchMAC = true
+	default:
+		fmt.Printf("❌ Invalid match '%s': must be 'ip' or 'mac'\n", options["match"])
+		return
+	}
 
 	// Get target host
-	targetHost, exists := s.store.Hosts[int64(hostId)]
+	targetHost, exists := s.store.GetHost(int64(hostId))
 	if !exists {
 		fmt.Printf("❌ Host with ID %d not found\n", hostId)
 		fmt.Println("💡 Use 'list' command to see available hosts")
 		return
 	}
 
+	uid, cgroup := options["uid"], options["cgroup"]
+	if (uid != "" || cgroup != "") && !targetHost.Self {
+		fmt.Println("❌ 'uid' and 'cgroup' only apply to this machine (self)")
+		return
+	}
+	if targetHost.Self && matchMAC {
+		fmt.Println("❌ 'match=mac' does not apply to this machine (self)")
+		return
+	}
+
+	if targetHost.Priority != "" {
+		fmt.Printf("❌ Host %s is prioritized; use 'prioritize %d none' before limiting it\n", targetHost.IP, hostId)
+		return
+	}
+
 	fmt.Printf("🎯 Target: %s (%s)\n", targetHost.IP, targetHost.Hostname)
+	if len(targetHost.IPv6) > 0 {
+		fmt.Printf("🌐 IPv6: %s\n", joinIPs(targetHost.IPv6))
+	}
 	fmt.Printf("⬆️  Upload Limit: %s\n", uploadRate)
 	fmt.Printf("⬇️  Download Limit: %s\n", downloadRate)
-	fmt.Printf("🔌 Interface: %s\n", s.store.Iface.Name)
+	if profileName != "" {
+		fmt.Printf("📦 Profile: %s\n", profileName)
+	}
+	if extra := spec.WithoutRates().String(); extra != "" {
+		fmt.Printf("🧪 Shaping: %s\n", extra)
+	}
+	if targetHost.Self {
+		fmt.Printf("🔌 Interface: %s\n", s.store.SelfLimiter.Interface().Name)
+	} else if targetHost.Device != "" {
+		fmt.Printf("🔌 Interface: %s\n", targetHost.Device)
+	} else if s.store.WANIface != nil {
+		fmt.Printf("🔌 Interface: %s (upload on %s)\n", s.store.ShapeIface.Name, s.store.WANIface.Name)
+	} else {
+		fmt.Printf("🔌 Interface: %s\n", s.store.ShapeIface.Name)
+	}
+	fmt.Printf("⚙️  Backend: %s\n", s.store.LimiterFor(&targetHost).Name())
+	if matchMAC {
+		fmt.Printf("🔗 Match: MAC %s\n", targetHost.MAC)
+	}
+	if uid != "" {
+		fmt.Printf("👤 Only user: %s\n", uid)
+	}
+	if cgroup != "" {
+		fmt.Printf("📁 Only cgroup: %s\n", cgroup)
+	}
 
-	// Start ARP spoofing
-	s.store.SpoofManager.Start(targetHost, s.store.Iface, s.store.GatewayIP, s.store.GatewayMAC)
+	// Start ARP spoofing, poisoning only the side carrying the limited traffic
+	err = s.store.Redirect(store.OwnerLimit, &targetHost, spoof.Options{
+		Direction: spoof.DirectionFor(spec.UploadRate != "", spec.DownloadRate != ""),
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to start ARP spoofing for %s: %v\n", targetHost.IP, err)
+		return
+	}
 
-	// Apply limit via limiter
-	err = s.store.Limiter.Apply(targetHost.IP.String(), uploadRate, downloadRate)
+	// Apply the limit to the host's current addresses, which may have changed
+	// since it was looked up
+	wasLimited := targetHost.Limited
+	err = s.store.UpdateHost(targetHost.ID, func(host *store.Host) error {
+		wasLimited = host.Limited
+		backend := s.store.LimiterFor(host)
+
+		// Drop rules from a previous limit in case the match mode changed
+		if host.Limited && host.MatchMAC != matchMAC {
+			backend.Remove(host.LimitTarget())
+		}
+
+		target := host.LimitTarget()
+		target.MatchMAC = matchMAC
+		target.UID, target.Cgroup = uid, cgroup
+		if err := backend.Apply(target, spec); err != nil {
+			return err
+		}
+
+		host.Limited = true
+		host.Spec = spec
+		host.Profile = profileName
+		host.Overrides = overrides
+		host.MatchMAC = matchMAC
+		host.UID = uid
+		host.Cgroup = cgroup
+		return nil
+	})
 	if err != nil {
 		fmt.Printf("❌ Failed to apply rate limit: %v\n", err)
+		if !wasLimited {
+			s.store.SpoofManager.Release(store.OwnerLimit, targetHost.ID)
+		}
 		return
 	}
 
-	s.store.Hosts[targetHost.ID].Limited = true
-	s.store.Hosts[targetHost.ID].DownloadSpeed = downloadRate
-	s.store.Hosts[targetHost.ID].UploadSpeed = uploadRate
-
 	fmt.Printf("✅ Limit applied for %s (Up: %s, Down: %s)\n", targetHost.IP, uploadRate, downloadRate)
 }
+
+// applyOverrides sets each of overrides on spec, in sorted order so aliases
+// such as up and upload resolve the same way every time
+func applyOverrides(spec *limiter.Spec, overrides map[string]string) error {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := spec.Set(key, overrides[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatOverrides lists overrides as sorted key=value pairs
+func formatOverrides(overrides map[string]string) string {
+	parts := make([]string, 0, len(overrides))
+	for key, value := range overrides {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,32 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitOptions separates positional arguments from key=value options
+func splitOptions(args []string) ([]string, []string) {
+	var positional, options []string
+	for _, arg := range args {
+		if strings.Contains(arg, "=") {
+			options = append(options, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return positional, options
+}
+
+// parseOptions parses trailing key=value command arguments
+func parseOptions(args []string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid option '%s': expected key=value", arg)
+		}
+		options[strings.ToLower(key)] = value
+	}
+	return options, nil
+}
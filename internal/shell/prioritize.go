@@ -0,0 +1,135 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/spoof"
+	"github.com/prabalesh/slayer/internal/store"
+)
+
+func (s *ShellSession) Prioritize(args []string) {
+	if len(args) < 1 {
+		fmt.Println("❌ Usage: prioritize <host_id> [high|medium|low|none]")
+		fmt.Println("❌        prioritize link <rate>")
+		fmt.Println("💡 Example: prioritize 3 high")
+		fmt.Println("💡 Prioritized hosts get a guaranteed share of the link and everyone else borrows the rest")
+		return
+	}
+
+	prioritizer, ok := s.store.Limiter.(limiter.Prioritizer)
+	if !ok {
+		fmt.Printf("❌ Priority mode is not supported by the %s limiter backend\n", s.store.Limiter.Name())
+		return
+	}
+
+	if args[0] == "link" {
+		if len(args) < 2 {
+			printLinkRate(prioritizer)
+			return
+		}
+		if err := prioritizer.SetLinkRate(strings.TrimSpace(args[1])); err != nil {
+			fmt.Printf("❌ Invalid link rate: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Link rate set to %s\n", args[1])
+		return
+	}
+
+	// Parse host ID
+	hostId, err := strconv.Atoi(args[0])
+	if err != nil || hostId < 0 {
+		fmt.Printf("❌ Invalid host ID '%s': must be a positive number\n", args[0])
+		return
+	}
+
+	tierName := "high"
+	if len(args) > 1 {
+		tierName = strings.ToLower(strings.TrimSpace(args[1]))
+	}
+
+	// Get target host
+	targetHost, exists := s.store.GetHost(int64(hostId))
+	if !exists {
+		fmt.Printf("❌ Host with ID %d not found\n", hostId)
+		fmt.Println("💡 Use 'list' command to see available hosts")
+		return
+	}
+	if targetHost.Self {
+		fmt.Println("❌ This machine's own traffic cannot be prioritized, use 'limit' instead")
+		return
+	}
+
+	if tierName == "none" {
+		if targetHost.Priority == "" {
+			fmt.Printf("⚠️  Host %s (%s) is not currently prioritized\n", targetHost.IP, targetHost.Hostname)
+			return
+		}
+		err := s.store.UpdateHost(targetHost.ID, func(host *store.Host) error {
+			if err := prioritizer.Unprioritize(host.IP.String()); err != nil {
+				return err
+			}
+			host.Priority = ""
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to remove priority for %s: %v\n", targetHost.IP, err)
+			return
+		}
+		s.store.SpoofManager.Release(store.OwnerPriority, targetHost.ID)
+		fmt.Printf("✅ Priority removed for %s (%s)\n", targetHost.IP, targetHost.Hostname)
+		return
+	}
+
+	if _, ok := limiter.LookupPriorityTier(tierName); !ok {
+		fmt.Printf("❌ Unknown priority level '%s'\n", tierName)
+		fmt.Println("💡 Available levels: high, medium, low, none")
+		return
+	}
+
+	if targetHost.Limited {
+		fmt.Printf("❌ Host %s is rate limited; use 'unlimit %d' before prioritizing it\n", targetHost.IP, hostId)
+		return
+	}
+
+	fmt.Printf("🎯 Target: %s (%s)\n", targetHost.IP, targetHost.Hostname)
+	fmt.Printf("🏅 Priority: %s\n", tierName)
+	printLinkRate(prioritizer)
+
+	// Start ARP spoofing
+	err = s.store.Redirect(store.OwnerPriority, &targetHost, spoof.Options{})
+	if err != nil {
+		fmt.Printf("❌ Failed to start ARP spoofing for %s: %v\n", targetHost.IP, err)
+		return
+	}
+
+	wasPrioritized := targetHost.Priority != ""
+	err = s.store.UpdateHost(targetHost.ID, func(host *store.Host) error {
+		wasPrioritized = host.Priority != ""
+		if err := prioritizer.Prioritize(host.IP.String(), host.IPv6Strings(), tierName); err != nil {
+			return err
+		}
+		host.Priority = tierName
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to prioritize host: %v\n", err)
+		if !wasPrioritized {
+			s.store.SpoofManager.Release(store.OwnerPriority, targetHost.ID)
+		}
+		return
+	}
+	fmt.Printf("✅ %s placed in %s priority tier\n", targetHost.IP, tierName)
+}
+
+// printLinkRate shows the link rate priority bands are sized from and where
+// it comes from
+func printLinkRate(prioritizer limiter.Prioritizer) {
+	source := prioritizer.LinkRateSource()
+	fmt.Printf("🔗 Link rate: %s (%s)\n", prioritizer.LinkRate(), source)
+	if source == "assumed" {
+		fmt.Println("💡 The interface reports no speed, set the real one with 'prioritize link <rate>'")
+	}
+}
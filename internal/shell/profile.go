@@ -0,0 +1,167 @@
+package shell
+
+import (
+	"fmt"
+
+	"github.com/prabalesh/slayer/internal/profile"
+	"github.com/prabalesh/slayer/internal/spoof"
+	"github.com/prabalesh/slayer/internal/store"
+)
+
+func (s *ShellSession) Profile(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Usage: profile <list|show|set|delete> [name] [key=value...]")
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		s.DisplayProfiles()
+	case "show":
+		if len(args) < 2 {
+			fmt.Println("❌ Usage: profile show <name>")
+			return
+		}
+		p, exists := s.store.Profiles.Get(args[1])
+		if !exists {
+			fmt.Printf("❌ Profile '%s' not found\n", args[1])
+			return
+		}
+		fmt.Printf("📦 %s: %s\n", p.Name, p.Spec)
+	case "set":
+		s.setProfile(args[1:])
+	case "delete":
+		if len(args) < 2 {
+			fmt.Println("❌ Usage: profile delete <name>")
+			return
+		}
+		for _, host := range s.store.ListHosts() {
+			if host.Profile == args[1] {
+				fmt.Printf("❌ Profile '%s' is in use by host %d (%s)\n", args[1], host.ID, host.IP)
+				return
+			}
+		}
+		if err := s.store.Profiles.Delete(args[1]); err != nil {
+			fmt.Printf("❌ Failed to delete profile: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Profile '%s' deleted\n", args[1])
+	default:
+		fmt.Printf("❌ Unknown profile command: '%s'\n", args[0])
+		fmt.Println("💡 Available options: list, show, set, delete")
+	}
+}
+
+// setProfile creates or edits a profile. Only the given keys change when the
+// profile already exists.
+func (s *ShellSession) setProfile(args []string) {
+	positional, optionArgs := splitOptions(args)
+	if len(positional) != 1 || len(optionArgs) == 0 {
+		fmt.Println("❌ Usage: profile set <name> key=value... [reapply=yes]")
+		fmt.Println("💡 Keys: up, down, ceil, burst, qdisc, delay, jitter, loss (use 'none' to clear)")
+		fmt.Println("💡 Example: profile set sd-video up=1mbit down=3mbit qdisc=fq_codel reapply=yes")
+		return
+	}
+
+	options, err := parseOptions(optionArgs)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	p, exists := s.store.Profiles.Get(positional[0])
+	if !exists {
+		p = profile.Profile{Name: positional[0]}
+	}
+
+	reapply := false
+	for key, value := range options {
+		if key == "reapply" {
+			reapply = value == "yes" || value == "true"
+			continue
+		}
+		if err := p.Spec.Set(key, value); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+	}
+
+	if err := s.store.Profiles.Set(p); err != nil {
+		fmt.Printf("❌ Failed to save profile: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Profile '%s' saved to %s (%s)\n", p.Name, s.store.Profiles.Path(), p.Spec)
+
+	if !reapply {
+		return
+	}
+
+	// Push the new settings to every host limited with this profile
+	for _, host := range s.store.ListHosts() {
+		if !host.Limited || host.Profile != p.Name {
+			continue
+		}
+
+		// Settings given with the limit still win over the profile's
+		spec := p.Spec
+		if err := applyOverrides(&spec, host.Overrides); err != nil {
+			fmt.Printf("❌ Failed to re-apply profile to %s: %v\n", host.IP, err)
+			continue
+		}
+		if !spec.HasRates() {
+			fmt.Printf("❌ Not re-applying profile to %s: no upload or download rate left\n", host.IP)
+			continue
+		}
+		if err := spec.Validate(); err != nil {
+			fmt.Printf("❌ Failed to re-apply profile to %s: %v\n", host.IP, err)
+			continue
+		}
+
+		// The new rates may need the other side of the traffic poisoned too
+		err := s.store.Redirect(store.OwnerLimit, &host, spoof.Options{
+			Direction: spoof.DirectionFor(spec.UploadRate != "", spec.DownloadRate != ""),
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to update ARP spoofing for %s: %v\n", host.IP, err)
+			continue
+		}
+
+		err = s.store.UpdateHost(host.ID, func(h *store.Host) error {
+			if !h.Limited || h.Profile != p.Name {
+				return nil // unlimited meanwhile
+			}
+			if err := s.store.LimiterFor(h).Apply(h.LimitTarget(), spec); err != nil {
+				return err
+			}
+			h.Spec = spec
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to re-apply profile to %s: %v\n", host.IP, err)
+			continue
+		}
+		if len(host.Overrides) > 0 {
+			fmt.Printf("🔄 Re-applied profile '%s' to %s (%s), keeping its own %s\n", p.Name, host.IP, host.Hostname, formatOverrides(host.Overrides))
+		} else {
+			fmt.Printf("🔄 Re-applied profile '%s' to %s (%s)\n", p.Name, host.IP, host.Hostname)
+		}
+	}
+}
+
+func (s *ShellSession) DisplayProfiles() {
+	profiles := s.store.Profiles.List()
+	if len(profiles) == 0 {
+		fmt.Println("❌ No profiles defined")
+		return
+	}
+
+	fmt.Println("\n📦 Limit Profiles:")
+	fmt.Println("════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("%-16s %-10s %-10s %s\n", "Name", "Upload", "Download", "Shaping")
+	fmt.Println("────────────────────────────────────────────────────────────────────────────────")
+	for _, p := range profiles {
+		fmt.Printf("%-16s %-10s %-10s %s\n", p.Name, p.UploadRate, p.DownloadRate, p.Spec.WithoutRates())
+	}
+	fmt.Println("════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("📁 Profiles file: %s\n\n", s.store.Profiles.Path())
+}
@@ -1,16 +1,33 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/prabalesh/slayer/internal/networking"
+	"github.com/prabalesh/slayer/internal/networking/ndp"
 	"github.com/prabalesh/slayer/internal/scanner"
+	"github.com/prabalesh/slayer/internal/store"
 )
 
 func (s *ShellSession) RunNetworkScan() {
+	if s.store.Mode == store.ModeLocal {
+		s.runLocalScan()
+		return
+	}
 	fmt.Printf("🌐 Detected interface: %s\n", s.store.Iface.Name)
+	if s.store.VLAN != 0 {
+		fmt.Printf("🏷️  VLAN: %d (via %s)\n", s.store.VLAN, s.store.ShapeIface.Name)
+	}
+	if s.store.Mode == store.ModeRouter {
+		fmt.Printf("📡 Router mode: WAN %s, no spoofing needed\n", s.store.WANIface.Name)
+	}
+	if s.store.Mode == store.ModeBridge {
+		s.runBridgeScan()
+		return
+	}
 	fmt.Printf("📍 Detected CIDR: %s\n", s.store.CIDR)
 
 	ips, err := networking.GenerateIPsFromCIDR(s.store.CIDR)
@@ -18,17 +35,59 @@ func (s *ShellSession) RunNetworkScan() {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("🎯 Scanning %d IPs using optimized ARP method (most accurate)...\n", len(ips))
+	arpScanner := scanner.NewArpScanner(s.store)
+	fmt.Printf("🎯 Sweeping %d IPs with ARP at %d requests/s...\n", len(ips), arpScanner.Rate())
 
 	startedTime := time.Now()
 
-	// Use the optimized ARP scanner for maximum accuracy
-	optimizedScanner := scanner.NewArpScanner(&s.store)
-	optimizedScanner.Scan(ips)
+	if err := arpScanner.Scan(ips); err != nil {
+		fmt.Printf("❌ ARP scan failed: %v\n", err)
+	}
+
+	// Dual-stack hosts bypass ARP spoofing over IPv6, so find their addresses too
+	if found, err := s.store.DiscoverIPv6(); err == nil {
+		fmt.Printf("🌐 IPv6 addresses found for %d hosts\n", found)
+	} else if !errors.Is(err, ndp.ErrNoIPv6) {
+		fmt.Printf("⚠️  IPv6 discovery failed: %v\n", err)
+	}
 
 	timeTaken := time.Since(startedTime)
 
 	fmt.Println("\n✅ Scan completed!")
 	s.DisplayActiveHosts()
-	fmt.Printf("⏱️  Time taken: %v (optimized for accuracy + performance)\n", timeTaken)
+	fmt.Printf("⏱️  Time taken: %v\n", timeTaken)
+}
+
+// runLocalScan lists the containers and VMs on this machine. Nothing goes
+// over the network, so it returns immediately.
+func (s *ShellSession) runLocalScan() {
+	fmt.Println("🖥️  Local mode: looking for containers and VMs behind veth and tap interfaces...")
+
+	skipped, err := scanner.NewLocalScanner(s.store).Scan()
+	if err != nil {
+		fmt.Printf("❌ Local scan failed: %v\n", err)
+		return
+	}
+
+	fmt.Println("\n✅ Scan completed!")
+	s.DisplayActiveHosts()
+	if skipped > 0 {
+		fmt.Printf("💡 %d workloads have no known IPv4 address yet; they show up once they talk to this machine\n", skipped)
+	}
+}
+
+// runBridgeScan discovers hosts behind the bridge's LAN port passively, as
+// the bridge may have no address to probe from.
+func (s *ShellSession) runBridgeScan() {
+	fmt.Printf("🌉 Bridge mode: %s (uplink %s)\n", s.store.Bridge.Name, s.store.WANIface.Name)
+	fmt.Printf("👂 Listening for hosts on %s for %v...\n", s.store.Iface.Name, scanner.BridgeListenTime)
+
+	if err := scanner.NewBridgeScanner(s.store).Scan(); err != nil {
+		fmt.Printf("❌ Bridge scan failed: %v\n", err)
+		return
+	}
+
+	fmt.Println("\n✅ Scan completed!")
+	s.DisplayActiveHosts()
+	fmt.Println("💡 Idle hosts may not show up yet; scan again to catch more")
 }
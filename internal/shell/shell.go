@@ -8,19 +8,25 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/chzyer/readline"
+	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/networking/sysctl"
 	"github.com/prabalesh/slayer/internal/store"
 	"github.com/prabalesh/slayer/internal/utils/color"
 )
 
 type ShellSession struct {
-	store store.Store
+	store *store.Store
 	rl    *readline.Instance
+
+	quit      chan struct{} // stops event rendering
+	closeOnce sync.Once
 }
 
-func NewShell(s store.Store) *ShellSession {
+func NewShell(s *store.Store) *ShellSession {
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:          color.BlueText("⚡ slayer> ", false),
 		HistoryFile:     "/tmp/slayer_history.tmp",
@@ -34,6 +40,7 @@ func NewShell(s store.Store) *ShellSession {
 	return &ShellSession{
 		store: s,
 		rl:    rl,
+		quit:  make(chan struct{}),
 	}
 }
 
@@ -67,6 +74,32 @@ func (s *ShellSession) CheckRequirements() error {
 		}
 	}
 
+	// Kernel settings are only changed once spoofing starts, so just report
+	// them. As the hosts' router we never spoof and forwarding is already on.
+	pending, err := s.store.Sysctl.Check()
+	if s.store.Mode == store.ModeLocal {
+		fmt.Println(color.GreenText("✅ Local mode: shaping containers and VMs on their own interfaces, no spoofing", false))
+	} else if s.store.Mode == store.ModeRouter {
+		fmt.Print(color.GreenText(fmt.Sprintf("✅ Router mode: shaping download on %s and upload on %s, no spoofing\n", s.store.ShapeIface.Name, s.store.WANIface.Name), false))
+	} else if s.store.Mode == store.ModeBridge {
+		if err != nil || len(pending) > 0 {
+			fmt.Println(color.RedText("❌ Bridged traffic bypasses iptables, limits would not apply (is br_netfilter loaded?)", false))
+			allPassed = false
+		} else {
+			fmt.Print(color.GreenText(fmt.Sprintf("✅ Bridge mode: %s passes bridged traffic to iptables, no spoofing\n", s.store.Bridge.Name), false))
+		}
+	} else if err != nil {
+		fmt.Print(color.RedText(fmt.Sprintf("❌ Unable to read kernel settings: %v\n", err), false))
+		allPassed = false
+	} else if len(pending) == 0 {
+		fmt.Println(color.GreenText("✅ IP forwarding enabled and ICMP redirects disabled", false))
+	} else {
+		for _, setting := range pending {
+			current, _ := sysctl.Get(setting.Key)
+			fmt.Print(color.YellowText(fmt.Sprintf("⚠️  %s = %s (will be set to %s while spoofing)\n", setting.Name(), current, setting.Value), false))
+		}
+	}
+
 	fmt.Println()
 
 	if !allPassed {
@@ -86,6 +119,8 @@ func (s *ShellSession) Start() {
 		os.Exit(1)
 	}
 
+	go s.renderEvents()
+
 	for {
 		input := s.readInput()
 		if input == "" {
@@ -175,6 +210,12 @@ func (s *ShellSession) executeCommand(command string, args []string) {
 		s.Limit(args)
 	case "unlimit":
 		s.Unlimit(args)
+	case "prioritize":
+		s.Prioritize(args)
+	case "connlimit":
+		s.ConnLimit(args)
+	case "profile":
+		s.Profile(args)
 	case "spoof":
 		s.Spoof(args)
 	case "clear":
@@ -186,18 +227,37 @@ func (s *ShellSession) executeCommand(command string, args []string) {
 }
 
 func (s *ShellSession) Close() {
+	s.closeOnce.Do(func() { close(s.quit) })
 	s.rl.Close() // Close the readline instance
 
-	for _, host := range s.store.Hosts {
+	// Give victims their real gateway back before tearing down limits
+	s.store.SpoofManager.Close()
+	s.drainEvents()
+
+	for _, host := range s.store.ListHosts() {
 		if host.Limited {
 			fmt.Printf("Removing limit on %s...\n", host.IP.String())
-			err := s.store.Limiter.Remove(host.IP.String())
+			err := s.store.LimiterFor(&host).Remove(host.LimitTarget())
 			if err != nil {
 				fmt.Printf("Can't remove limit on %s\n", host.IP.String())
 				return
 			}
 			fmt.Printf("Removed limit on %s\n", host.IP.String())
 		}
+		if prioritizer, ok := s.store.Limiter.(limiter.Prioritizer); ok && host.Priority != "" {
+			fmt.Printf("Removing priority on %s...\n", host.IP.String())
+			if err := prioritizer.Unprioritize(host.IP.String()); err != nil {
+				fmt.Printf("Can't remove priority on %s\n", host.IP.String())
+			}
+		}
 	}
 	s.store.Limiter.Cleanup()
+	if s.store.SelfLimiter != nil {
+		s.store.SelfLimiter.Cleanup()
+	}
+	s.store.Firewall.Cleanup()
+
+	if err := s.store.Sysctl.Restore(); err != nil {
+		fmt.Printf("Can't restore kernel settings: %v\n", err)
+	}
 }
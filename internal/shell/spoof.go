@@ -3,11 +3,15 @@ package shell
 import (
 	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/prabalesh/slayer/internal/spoof"
+	"github.com/prabalesh/slayer/internal/store"
 )
 
 func (s *ShellSession) Spoof(args []string) {
 	if len(args) == 0 {
-		fmt.Println("❌ Usage: spoof <list|stop> [host_id]")
+		fmt.Println("❌ Usage: spoof <list|start|stop|restart> [host_id...]")
 		return
 	}
 
@@ -15,25 +19,154 @@ func (s *ShellSession) Spoof(args []string) {
 	case "list":
 		fmt.Println("📋 Current spoofing sessions:")
 		s.store.DisplaySpoofList()
+	case "start":
+		s.startSpoof(args[1:])
 	case "stop":
-		if len(args) < 2 {
-			fmt.Println("❌ Usage: spoof stop <host_id>")
-			return
+		s.stopSpoof(args[1:])
+	case "restart":
+		s.restartSpoof(args[1:])
+	default:
+		fmt.Printf("❌ Unknown spoof command: '%s'\n", args[0])
+		fmt.Println("💡 Available options: list, start, stop, restart")
+	}
+}
+
+// startSpoof handles 'spoof start <host_id...> [target|gateway|both] [interval]'.
+// Hosts are redirected through us without any limit, e.g. to watch their
+// traffic or to prepare limits.
+func (s *ShellSession) startSpoof(args []string) {
+	var ids []string
+	var opts spoof.Options
+	for _, arg := range args {
+		if _, err := strconv.Atoi(arg); err == nil {
+			ids = append(ids, arg)
+			continue
 		}
-		hostId, err := strconv.Atoi(args[1])
+		if dir, err := spoof.ParseDirection(arg); err == nil {
+			opts.Direction = dir
+			continue
+		}
+		interval, err := spoof.ParseInterval(arg)
 		if err != nil {
-			fmt.Printf("❌ Invalid host ID '%s': must be a number\n", args[1])
+			fmt.Printf("❌ Invalid argument '%s': expected a host ID, direction or interval\n", arg)
 			return
 		}
-		if hostId < 0 {
-			fmt.Println("❌ Host ID must be a positive number")
+		opts.Interval = interval
+	}
+	if len(ids) == 0 {
+		fmt.Println("❌ Usage: spoof start <host_id...> [target|gateway|both] [interval]")
+		fmt.Println("💡 Example: spoof start 3 4 target 5s")
+		fmt.Println("💡 'target' redirects upload only, 'gateway' download only")
+		return
+	}
+
+	if s.store.Mode != store.ModeSpoof {
+		fmt.Printf("❌ %s mode: hosts already pass through this machine, nothing to spoof\n", s.store.Mode)
+		return
+	}
+
+	hosts, ok := s.lookupHosts(ids)
+	if !ok {
+		return
+	}
+	for _, host := range hosts {
+		if host.Self {
+			fmt.Printf("❌ Host %d is this machine, it cannot be spoofed\n", host.ID)
 			return
 		}
+	}
+	gatewayIP, gatewayMAC := s.store.Gateway()
+	failed := s.store.SpoofManager.AcquireMany(store.OwnerSpoof, hosts, s.store.Iface, gatewayIP, gatewayMAC, opts)
+	for _, host := range hosts {
+		if err, ok := failed[host.ID]; ok {
+			fmt.Printf("❌ Failed to start spoofing %s: %v\n", host.IP, err)
+			continue
+		}
+		session, active := s.store.SpoofManager.Session(host.ID)
+		if !active {
+			fmt.Printf("❌ Failed to start spoofing %s\n", host.IP)
+			continue
+		}
+		interval := session.Interval
+		if interval == 0 {
+			interval = spoof.DefaultInterval
+		}
+		fmt.Printf("✅ Spoofing %s (%s), direction %s, every %s\n", host.IP, host.Hostname, session.Direction, interval)
+	}
+}
 
-		s.store.SpoofManager.Stop(int64(hostId))
-		fmt.Printf("✅ Spoofing stopped for host ID: %d\n", hostId)
-	default:
-		fmt.Printf("❌ Unknown spoof command: '%s'\n", args[0])
-		fmt.Println("💡 Available options: list, stop")
+// stopSpoof drops the hold taken by 'spoof start'. Sessions still needed by a
+// limit stay up.
+func (s *ShellSession) stopSpoof(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Usage: spoof stop <host_id...>")
+		return
+	}
+	hosts, ok := s.lookupHosts(args)
+	if !ok {
+		return
+	}
+	for _, host := range hosts {
+		if !s.store.SpoofManager.IsActive(host.ID) {
+			fmt.Printf("⚠️  Host %s (%s) is not being spoofed\n", host.IP, host.Hostname)
+			continue
+		}
+		if s.store.SpoofManager.Release(store.OwnerSpoof, host.ID) {
+			holders := s.store.SpoofManager.Holders(host.ID)
+			fmt.Printf("⚠️  %s is still redirected for: %s\n", host.IP, strings.Join(holders, ", "))
+			fmt.Println("💡 Remove those first, e.g. with 'unlimit' or 'prioritize <id> none'")
+			continue
+		}
+		fmt.Printf("✅ Spoofing stopped for %s (%s)\n", host.IP, host.Hostname)
+	}
+}
+
+// restartSpoof restores and re-poisons sessions, e.g. after a victim's ARP
+// cache was reset. Without arguments every session is restarted.
+func (s *ShellSession) restartSpoof(args []string) {
+	var hosts []*store.Host
+	if len(args) == 0 {
+		for _, session := range s.store.SpoofManager.Sessions() {
+			if host, exists := s.store.GetHost(session.ID); exists {
+				hosts = append(hosts, &host)
+			}
+		}
+		if len(hosts) == 0 {
+			fmt.Println("⚠️  No active spoofing sessions")
+			return
+		}
+	} else {
+		var ok bool
+		if hosts, ok = s.lookupHosts(args); !ok {
+			return
+		}
+	}
+
+	for _, host := range hosts {
+		if !s.store.SpoofManager.Restart(host) {
+			fmt.Printf("⚠️  Host %s (%s) is not being spoofed\n", host.IP, host.Hostname)
+			continue
+		}
+		fmt.Printf("🔄 Spoofing restarted for %s (%s)\n", host.IP, host.Hostname)
+	}
+}
+
+// lookupHosts resolves host ID arguments, reporting the first invalid one
+func (s *ShellSession) lookupHosts(args []string) ([]*store.Host, bool) {
+	hosts := make([]*store.Host, 0, len(args))
+	for _, arg := range args {
+		hostId, err := strconv.Atoi(arg)
+		if err != nil || hostId < 0 {
+			fmt.Printf("❌ Invalid host ID '%s': must be a positive number\n", arg)
+			return nil, false
+		}
+		host, exists := s.store.GetHost(int64(hostId))
+		if !exists {
+			fmt.Printf("❌ Host with ID %d not found\n", hostId)
+			fmt.Println("💡 Use 'list' command to see available hosts")
+			return nil, false
+		}
+		hosts = append(hosts, &host)
 	}
+	return hosts, true
 }
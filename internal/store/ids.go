@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// DefaultIDsPath is where host IDs are remembered across sessions by default
+const DefaultIDsPath = "/var/lib/slayer/host-ids.json"
+
+// idRegistry hands out host IDs, remembering which MAC got which so a host
+// keeps its ID across scans and, when persisted, across restarts. Guarded by
+// the store's mu.
+type idRegistry struct {
+	path  string           // JSON file the IDs are saved to, empty to keep them in memory
+	byMAC map[string]int64 // MAC -> ID last given to it
+	next  int64            // lowest ID never handed out, SelfID is never one
+}
+
+// loadIDs reads the IDs saved in path. A missing file or an empty path
+// yields an empty registry.
+func loadIDs(path string) (*idRegistry, error) {
+	r := &idRegistry{
+		path:  path,
+		byMAC: make(map[string]int64),
+		next:  SelfID + 1,
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host IDs from %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &r.byMAC); err != nil {
+		return nil, fmt.Errorf("failed to parse host IDs in %s: %w", path, err)
+	}
+	for mac, id := range r.byMAC {
+		if id <= SelfID {
+			return nil, fmt.Errorf("invalid host ID %d for %s in %s", id, mac, path)
+		}
+		r.next = max(r.next, id+1)
+	}
+	return r, nil
+}
+
+// assign returns the ID for a new host with the given MAC: the one it had
+// before unless another host holds it now, a fresh one otherwise. Hosts
+// without a MAC always get a fresh ID.
+func (r *idRegistry) assign(mac net.HardwareAddr, hosts map[int64]*Host) (int64, error) {
+	if len(mac) == 0 {
+		return r.fresh(hosts), nil
+	}
+	if id, known := r.byMAC[mac.String()]; known {
+		if _, taken := hosts[id]; !taken {
+			return id, nil
+		}
+	}
+
+	id := r.fresh(hosts)
+	r.byMAC[mac.String()] = id
+	return id, r.save()
+}
+
+// move records that the host with id is now at newMAC, so it keeps its ID
+// across restarts. The old MAC is forgotten unless it was given another ID
+// since.
+func (r *idRegistry) move(oldMAC, newMAC net.HardwareAddr, id int64) error {
+	if len(oldMAC) > 0 && r.byMAC[oldMAC.String()] == id {
+		delete(r.byMAC, oldMAC.String())
+	}
+	if len(newMAC) > 0 {
+		r.byMAC[newMAC.String()] = id
+	}
+	return r.save()
+}
+
+// fresh returns an ID no host has had yet
+func (r *idRegistry) fresh(hosts map[int64]*Host) int64 {
+	for {
+		id := r.next
+		r.next++
+		if _, taken := hosts[id]; !taken {
+			return id
+		}
+	}
+}
+
+// save writes the registry to its file, if it has one
+func (r *idRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.byMAC, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode host IDs: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(r.path), err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save host IDs to %s: %w", r.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIDRegistryMove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host-ids.json")
+	r, err := loadIDs(path)
+	if err != nil {
+		t.Fatalf("loadIDs() = %v", err)
+	}
+	oldMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	newMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0b}
+
+	id, err := r.assign(oldMAC, nil)
+	if err != nil {
+		t.Fatalf("assign() = %v", err)
+	}
+	if err := r.move(oldMAC, newMAC, id); err != nil {
+		t.Fatalf("move() = %v", err)
+	}
+
+	// After a restart the new MAC gets the host's ID, the old one a fresh ID
+	r, err = loadIDs(path)
+	if err != nil {
+		t.Fatalf("loadIDs() = %v", err)
+	}
+	if got, _ := r.assign(newMAC, nil); got != id {
+		t.Errorf("ID of the new MAC = %d, want %d", got, id)
+	}
+	if got, _ := r.assign(oldMAC, nil); got == id {
+		t.Errorf("old MAC still gets ID %d", id)
+	}
+}
+
+func TestIDRegistryAssign(t *testing.T) {
+	macA := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	macB := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0b}
+	tests := []struct {
+		name   string
+		saved  map[string]int64 // registry before assigning
+		hosts  map[int64]*Host  // hosts in the store
+		mac    net.HardwareAddr
+		want   int64
+		stored bool // whether the MAC maps to want afterwards
+	}{
+		{"unknown MAC gets a fresh ID", nil, nil, macA, 1, true},
+		{"known MAC gets its ID back", map[string]int64{macA.String(): 5}, nil, macA, 5, true},
+		{"known MAC whose ID is taken gets a fresh one", map[string]int64{macA.String(): 5}, map[int64]*Host{5: {MAC: macB}}, macA, 6, true},
+		{"fresh IDs skip IDs in use", nil, map[int64]*Host{1: {}, 2: {}}, macA, 3, true},
+		{"fresh IDs skip saved IDs", map[string]int64{macB.String(): 3}, nil, macA, 4, true},
+		{"host without a MAC gets a fresh ID", map[string]int64{macB.String(): 3}, nil, nil, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := loadIDs("")
+			if err != nil {
+				t.Fatalf("loadIDs() = %v", err)
+			}
+			for mac, id := range tt.saved {
+				r.byMAC[mac] = id
+				r.next = max(r.next, id+1)
+			}
+
+			got, err := r.assign(tt.mac, tt.hosts)
+			if err != nil {
+				t.Fatalf("assign() = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("assign() = %d, want %d", got, tt.want)
+			}
+			if id, known := r.byMAC[tt.mac.String()]; tt.stored != (known && id == got) {
+				t.Errorf("registry maps %s to %d (known %t), want stored %t", tt.mac, id, known, tt.stored)
+			}
+		})
+	}
+}
+
+func TestLoadIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host-ids.json")
+	r, err := loadIDs(path)
+	if err != nil {
+		t.Fatalf("loadIDs() of a missing file = %v", err)
+	}
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	id, err := r.assign(mac, nil)
+	if err != nil {
+		t.Fatalf("assign() = %v", err)
+	}
+
+	r, err = loadIDs(path)
+	if err != nil {
+		t.Fatalf("loadIDs() = %v", err)
+	}
+	if r.byMAC[mac.String()] != id || r.next != id+1 {
+		t.Errorf("reloaded registry maps %s to %d with next %d, want %d and %d", mac, r.byMAC[mac.String()], r.next, id, id+1)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"02:00:00:00:00:0a": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIDs(path); err == nil {
+		t.Errorf("loadIDs() accepted the self host's ID")
+	}
+}
@@ -2,127 +2,1118 @@
 package store
 
 import (
-	"context"
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/prabalesh/slayer/internal/events"
+	"github.com/prabalesh/slayer/internal/firewall"
 	"github.com/prabalesh/slayer/internal/limiter"
 	"github.com/prabalesh/slayer/internal/networking"
+	"github.com/prabalesh/slayer/internal/networking/arp"
+	"github.com/prabalesh/slayer/internal/networking/bridge"
+	"github.com/prabalesh/slayer/internal/networking/ndp"
+	"github.com/prabalesh/slayer/internal/networking/sysctl"
+	"github.com/prabalesh/slayer/internal/networking/vlan"
+	"github.com/prabalesh/slayer/internal/profile"
 	"github.com/prabalesh/slayer/internal/spoof"
 )
 
 // NewStore creates and returns a fully initialized Store.
-func NewStore() (*Store, error) {
-	iface, err := networking.GetActiveWiFiInterface()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get active interface: %w", err)
+func NewStore(cfg Config) (*Store, error) {
+	var iface, shapeIface, wan *net.Interface
+	var br *bridge.Bridge
+	var vlanID uint16
+	var mode string
+	var err error
+	if cfg.Mode == ModeBridge {
+		// Inline, hosts sit behind the LAN port and the ports need no address
+		br, iface, wan, err = attachBridge(cfg)
+		if err != nil {
+			return nil, err
+		}
+		shapeIface, mode = iface, ModeBridge
+	} else if cfg.Mode == ModeLocal {
+		// Workloads are shaped on their own interfaces, found when scanning
+		mode = ModeLocal
+	} else {
+		iface, err = selectInterface(cfg.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get active interface: %w", err)
+		}
+
+		// On a trunk, frames are tagged by us but forwarded and shaped by the
+		// kernel on the VLAN's sub-interface
+		shapeIface = iface
+		if cfg.VLAN != 0 {
+			shapeIface, err = vlanInterface(iface, cfg.VLAN)
+			if err != nil {
+				return nil, err
+			}
+			vlanID = uint16(cfg.VLAN)
+		}
+		if _, err := arp.InterfaceIPv4(shapeIface); err != nil {
+			return nil, fmt.Errorf("failed to get interface address: %w", err)
+		}
+
+		mode, wan, err = selectMode(cfg, shapeIface)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	gatewayIP, err := networking.GetDefaultGatewayIP()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get gateway IP: %w", err)
+	// As the hosts' router there is no gateway to impersonate
+	var gatewayIP net.IP
+	var gatewayMAC net.HardwareAddr
+	if mode == ModeSpoof {
+		if vlanID != 0 {
+			gatewayIP, err = networking.GetDefaultGatewayIPOn(shapeIface.Name)
+		} else {
+			gatewayIP, err = networking.GetDefaultGatewayIP()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get gateway IP: %w", err)
+		}
+
+		gatewayMAC, err = networking.GetGatewayMAC(gatewayIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get gateway MAC address: %w", err)
+		}
 	}
 
-	gatewayMAC, err := networking.GetGatewayMAC(gatewayIP)
+	// A bridge only has an address if the box itself should be reachable
+	var cidr string
+	if mode == ModeBridge {
+		if bridgeIface, err := net.InterfaceByName(br.Name); err == nil {
+			cidr, _ = networking.GetInterfaceCIDR(bridgeIface)
+		}
+	} else if mode != ModeLocal {
+		cidr, err = networking.GetInterfaceCIDR(shapeIface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get interface CIDR: %w", err)
+		}
+	}
+
+	var newLimiter limiter.Backend
+	if mode == ModeLocal {
+		newLimiter = limiter.NewDeviceLimiter()
+		err = newLimiter.Init()
+	} else if mode == ModeRouter || mode == ModeBridge {
+		newLimiter, err = limiter.NewRouterBackend(shapeIface, wan, cfg.Backend)
+	} else {
+		newLimiter, err = limiter.NewBackend(shapeIface, cfg.Backend)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get gateway MAC address: %w", err)
+		return nil, fmt.Errorf("failed to initialize limiter: %w", err)
 	}
 
-	cidr, err := networking.GetInterfaceCIDR(iface)
+	profiles, err := profile.NewManager(cfg.ProfilesPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interface CIDR: %w", err)
+		return nil, fmt.Errorf("failed to load profiles: %w", err)
+	}
+	ids, err := loadIDs(cfg.HostIDsPath)
+	if err != nil {
+		return nil, err
 	}
 
-	newLimiter := limiter.NewLimiter(iface)
-	newLimiter.Init()
+	// Nothing is forwarded for local workloads, so their manager stays unused
+	sysctlManager := sysctl.NewManager("all")
+	if shapeIface != nil {
+		sysctlManager = sysctl.NewManager(shapeIface.Name)
+	}
+	if mode == ModeBridge {
+		// Limits mark bridged frames right away, there is no spoof session
+		// to enable the settings on demand
+		sysctlManager = sysctl.NewBridgeManager(br.Name)
+		if err := sysctlManager.Enable(); err != nil {
+			return nil, fmt.Errorf("failed to pass bridged traffic to iptables: %w", err)
+		}
+	}
+	fw := firewall.NewFirewall()
+	bus := events.NewBus(events.DefaultBufferSize)
 
 	store := &Store{
 		Iface:        iface,
+		ShapeIface:   shapeIface,
+		VLAN:         vlanID,
+		Mode:         mode,
+		WANIface:     wan,
+		Bridge:       br,
 		GatewayIP:    gatewayIP,
 		GatewayMAC:   gatewayMAC,
 		CIDR:         cidr,
 		Hosts:        make(map[int64]*Host),
-		SpoofManager: NewSpoofManager(),
+		SpoofManager: NewSpoofManager(sysctlManager, fw, bus, vlanID, cfg.SpoofPPS, cfg.SpoofMaxFailures),
 		Limiter:      newLimiter,
+		Firewall:     fw,
+		Profiles:     profiles,
+		Sysctl:       sysctlManager,
+		Events:       bus,
+		Scan:         cfg.Scan,
+		ids:          ids,
 	}
+	store.SpoofManager.onChange = store.followSpoofChange
+	store.addSelfHost()
 
 	return store, nil
 }
 
-// AddHost adds a new host or updates an existing one in the store.
-func (s *Store) AddHost(host *Host) {
+// SelfID is the ID of the host entry for this machine. Scanners number the
+// hosts they find from 1.
+const SelfID int64 = 0
+
+// addSelfHost lists this machine as host SelfID, shaped on the interface its
+// own traffic leaves through. Bridge ports and local mode have none.
+func (s *Store) addSelfHost() {
+	iface := s.ShapeIface
+	if s.Mode == ModeRouter {
+		iface = s.WANIface
+	}
+	if s.Mode == ModeBridge || iface == nil {
+		return
+	}
+	ip, err := arp.InterfaceIPv4(iface)
+	if err != nil {
+		return
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		name = "localhost"
+	}
+
+	s.SelfLimiter = limiter.NewSelfLimiter(iface)
+	s.SelfLimiter.Init()
+	s.Hosts[SelfID] = &Host{
+		ID:       SelfID,
+		IP:       ip,
+		MAC:      iface.HardwareAddr,
+		Hostname: name + " (self)",
+		Online:   true,
+		Self:     true,
+	}
+}
+
+// LimiterFor returns the backend shaping host: the self limiter for this
+// machine, the main backend for everyone else.
+func (s *Store) LimiterFor(host *Host) limiter.Backend {
+	if host.Self && s.SelfLimiter != nil {
+		return s.SelfLimiter
+	}
+	return s.Limiter
+}
+
+// selectInterface returns the named interface, or detects the active one.
+func selectInterface(name string) (*net.Interface, error) {
+	if name != "" {
+		return networking.LookupInterface(name)
+	}
+	return networking.GetActiveInterface()
+}
+
+// Modes accepted in Config.Mode
+const (
+	ModeAuto   = "auto"
+	ModeSpoof  = "spoof"  // hosts use another gateway, redirect them with ARP/NDP spoofing
+	ModeRouter = "router" // this machine is the hosts' gateway, nothing to redirect
+	ModeBridge = "bridge" // this machine bridges the hosts to their uplink, nothing to redirect
+	ModeLocal  = "local"  // hosts are containers and VMs on this machine, shaped on their own interfaces
+)
+
+// selectMode resolves cfg.Mode for the LAN interface. It returns the WAN
+// interface in router mode. Auto picks router mode when the kernel forwards
+// and the default route leaves through another interface than lan, as on a
+// hotspot or a Linux box acting as gateway.
+func selectMode(cfg Config, lan *net.Interface) (string, *net.Interface, error) {
+	switch cfg.Mode {
+	case "", ModeAuto, ModeRouter, ModeSpoof:
+	default:
+		return "", nil, fmt.Errorf("unknown mode: %s (expected %s, %s, %s, %s or %s)", cfg.Mode, ModeAuto, ModeSpoof, ModeRouter, ModeBridge, ModeLocal)
+	}
+	if cfg.Mode == ModeSpoof {
+		return ModeSpoof, nil, nil
+	}
+
+	var wan *net.Interface
+	var err error
+	if cfg.WANInterface != "" {
+		wan, err = networking.LookupInterface(cfg.WANInterface)
+	} else {
+		wan, err = networking.GetDefaultRouteInterface()
+	}
+	if cfg.Mode == ModeRouter {
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get WAN interface: %w", err)
+		}
+		if wan.Index == lan.Index {
+			return "", nil, fmt.Errorf("router mode needs separate LAN and WAN interfaces, both are %s", lan.Name)
+		}
+		return ModeRouter, wan, nil
+	}
+
+	if err != nil || wan.Index == lan.Index {
+		return ModeSpoof, nil, nil
+	}
+	if forwarding, err := sysctl.Get("net/ipv4/ip_forward"); err != nil || forwarding != "1" {
+		return ModeSpoof, nil, nil
+	}
+	// A second uplink on lan means hosts there have a gateway of their own
+	if _, err := networking.GetDefaultGatewayIPOn(lan.Name); err == nil {
+		return ModeSpoof, nil, nil
+	}
+	return ModeRouter, wan, nil
+}
+
+// attachBridge puts the LAN interface (hosts' side) and the WAN interface
+// (uplink) into cfg.Bridge, creating it if needed, and makes bridged traffic
+// visible to iptables. It returns the bridge and both ports.
+func attachBridge(cfg Config) (*bridge.Bridge, *net.Interface, *net.Interface, error) {
+	if cfg.Interface == "" || cfg.WANInterface == "" {
+		return nil, nil, nil, fmt.Errorf("bridge mode needs the hosts' side with -i and the uplink with -wan")
+	}
+	if cfg.VLAN != 0 {
+		return nil, nil, nil, fmt.Errorf("bridge mode does not support -vlan, bridge the VLAN device instead")
+	}
+	if cfg.Interface == cfg.WANInterface {
+		return nil, nil, nil, fmt.Errorf("bridge mode needs separate LAN and WAN interfaces, both are %s", cfg.Interface)
+	}
+	lan, err := networking.LookupInterface(cfg.Interface)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	wan, err := networking.LookupInterface(cfg.WANInterface)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	br, err := bridge.Attach(cfg.Bridge, lan.Name, wan.Name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := bridge.LoadNetfilter(); err != nil {
+		return nil, nil, nil, err
+	}
+	return br, lan, wan, nil
+}
+
+// vlanInterface returns the sub-interface carrying VLAN id on trunk. The
+// kernel needs it, with an address, to forward the victims' traffic.
+func vlanInterface(trunk *net.Interface, id int) (*net.Interface, error) {
+	if err := vlan.Validate(id); err != nil {
+		return nil, err
+	}
+	if device, isVLAN, err := vlan.Lookup(trunk.Name); err == nil && isVLAN {
+		return nil, fmt.Errorf("%s is already VLAN %d on %s; use -i %s without -vlan", trunk.Name, device.ID, device.Parent, trunk.Name)
+	}
+
+	device, exists, err := vlan.Find(trunk.Name, uint16(id))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no device for VLAN %d on %s, create one with 'ip link add link %s name %s.%d type vlan id %d' and give it an address", id, trunk.Name, trunk.Name, trunk.Name, id, id)
+	}
+	return networking.LookupInterface(device.Name)
+}
+
+// AddHost records a host found by a scan and returns a copy of the record
+// kept in the store. A host already known by its MAC keeps its record and ID:
+// the scan's data is merged into it and its limits, priority and spoofing
+// follow any change of address. Only hosts found without a MAC are matched by
+// IP. A new MAC gets a new record, with the ID it had before if any, so IDs
+// stay stable across scans and restarts. A known host whose IP was taken over
+// is marked offline. The ID of host is ignored.
+func (s *Store) AddHost(host *Host) (Host, bool) {
 	if host == nil || host.IP == nil {
+		return Host{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var existing *Host
+	if len(host.MAC) > 0 {
+		existing = s.findHostByMAC(host.MAC)
+	} else {
+		existing = s.findHostByIP(host.IP)
+	}
+
+	// Another device took over the address, its limits stay with its record
+	if previous := s.findHostByIP(host.IP); previous != nil && previous != existing && !previous.Self {
+		previous.Online = false
+		s.publish(events.HostMoved, previous, fmt.Sprintf("%s is now used by %s (was %s)", host.IP, host.MAC, previous.MAC), nil)
+	}
+
+	if existing != nil {
+		s.mergeHost(existing, host)
+		return *existing, true
+	}
+
+	id, err := s.ids.assign(host.MAC, s.Hosts)
+	host.ID = id
+	host.Online = true
+	s.Hosts[id] = host
+	if err != nil {
+		s.publish(events.WorkerError, host, "failed to remember host ID", err)
+	}
+	return *host, true
+}
+
+// findHostByMAC returns the known host with the given MAC, if any.
+func (s *Store) findHostByMAC(mac net.HardwareAddr) *Host {
+	if len(mac) == 0 {
+		return nil
+	}
+	for _, existing := range s.Hosts {
+		if bytes.Equal(existing.MAC, mac) {
+			return existing
+		}
+	}
+	return nil
+}
+
+// findHostByIP returns the known host with the given IPv4 address, if any.
+func (s *Store) findHostByIP(ip net.IP) *Host {
+	for _, existing := range s.Hosts {
+		if existing.IP.Equal(ip) {
+			return existing
+		}
+	}
+	return nil
+}
+
+// mergeHost updates a known host with what a scan found about it. Must be
+// called with mu held.
+func (s *Store) mergeHost(host, found *Host) {
+	if !host.IP.Equal(found.IP) {
+		s.moveHost(host, found.IP)
+	}
+	if found.Device != "" && found.Device != host.Device {
+		s.changeDevice(host, found.Device)
+	}
+	if merged := mergeIPs(host.IPv6, found.IPv6); !sameIPs(merged, host.IPv6) {
+		s.setIPv6(host, merged)
+	}
+	if found.Hostname != "" {
+		host.Hostname = found.Hostname
+	}
+	host.Online = true
+}
+
+// changeDevice moves a local workload's limit to the interface it is now
+// behind. Must be called with mu held.
+func (s *Store) changeDevice(host *Host, device string) {
+	oldTarget := host.LimitTarget()
+	host.Device = device
+
+	if host.Limited {
+		s.LimiterFor(host).Remove(oldTarget)
+		if err := s.LimiterFor(host).Apply(host.LimitTarget(), host.Spec); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply limit", err)
+			host.Limited = false
+		}
+	}
+}
+
+// MoveHost updates a host's IP address and re-applies everything keyed on the
+// old address: bandwidth limits, priority, connection limits and spoofing.
+func (s *Store) MoveHost(host *Host, newIP net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveHost(host, newIP)
+}
+
+// moveHost implements MoveHost. Must be called with mu held.
+func (s *Store) moveHost(host *Host, newIP net.IP) {
+	oldIP := host.IP
+	oldTarget := host.LimitTarget()
+	s.publish(events.HostMoved, host, fmt.Sprintf("%s moved from %s to %s", host.MAC, oldIP, newIP), nil)
+
+	host.IP = newIP
+
+	if host.Limited {
+		s.LimiterFor(host).Remove(oldTarget)
+		if err := s.LimiterFor(host).Apply(host.LimitTarget(), host.Spec); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply limit", err)
+			host.Limited = false
+		}
+	}
+
+	if prioritizer, ok := s.Limiter.(limiter.Prioritizer); ok && host.Priority != "" {
+		prioritizer.Unprioritize(oldIP.String())
+		if err := prioritizer.Prioritize(newIP.String(), host.IPv6Strings(), host.Priority); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply priority", err)
+			host.Priority = ""
+		}
+	}
+
+	if host.ConnLimit > 0 {
+		s.Firewall.RemoveConnLimit(oldIP.String())
+		if err := s.Firewall.LimitConnections(newIP.String(), host.IPv6Strings(), host.ConnLimit); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply connection limit", err)
+			host.ConnLimit = 0
+		}
+	}
+
+	// Spoof session may still point at the old address
+	s.SpoofManager.Retarget(host)
+}
+
+// changeMAC updates the MAC behind a host's IP. The host keeps its ID under
+// the new MAC. Only limits matching on the MAC need re-applying. Must be
+// called with mu held.
+func (s *Store) changeMAC(host *Host, newMAC net.HardwareAddr) {
+	oldTarget := host.LimitTarget()
+	s.publish(events.HostMoved, host, fmt.Sprintf("%s is now at %s (was %s)", host.IP, newMAC, host.MAC), nil)
+
+	if err := s.ids.move(host.MAC, newMAC, host.ID); err != nil {
+		s.publish(events.WorkerError, host, "failed to remember host ID", err)
+	}
+	host.MAC = newMAC
+
+	if host.Limited && host.MatchMAC {
+		s.LimiterFor(host).Remove(oldTarget)
+		if err := s.LimiterFor(host).Apply(host.LimitTarget(), host.Spec); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply limit", err)
+			host.Limited = false
+		}
+	}
+
+	s.SpoofManager.Retarget(host)
+}
+
+// followSpoofChange applies an address change noticed by the spoof engine.
+func (s *Store) followSpoofChange(change spoof.Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if change.Gateway {
+		s.GatewayMAC = change.MAC
+		s.Events.Publish(events.Event{
+			Kind:    events.GatewayChanged,
+			Source:  "store",
+			IP:      change.IP,
+			Message: fmt.Sprintf("gateway %s is now at %s (was %s)", change.IP, change.MAC, change.OldMAC),
+		})
 		return
 	}
-	s.Hosts[host.ID] = host
+
+	host, exists := s.Hosts[change.HostID]
+	if !exists {
+		return
+	}
+	if !host.IP.Equal(change.IP) {
+		s.moveHost(host, change.IP)
+	}
+	if !bytes.Equal(host.MAC, change.MAC) {
+		s.changeMAC(host, change.MAC)
+	}
+}
+
+// DiscoverWait is how long DiscoverIPv6 listens for answers
+const DiscoverWait = time.Second
+
+// DiscoverIPv6 finds the IPv6 addresses of known hosts and of the router so
+// spoofing and limits cover IPv6 too. Hosts gaining addresses have their
+// limits re-applied and spoof sessions retargeted. It returns how many hosts
+// have IPv6 addresses, or ndp.ErrNoIPv6 when the interface has none.
+func (s *Store) DiscoverIPv6() (int, error) {
+	neighbors, err := ndp.Discover(s.ShapeIface, DiscoverWait)
+	if err != nil {
+		return 0, err
+	}
+
+	byMAC := make(map[string][]net.IP)
+	for _, neighbor := range neighbors {
+		byMAC[neighbor.MAC.String()] = append(byMAC[neighbor.MAC.String()], neighbor.IP)
+	}
+
+	// Hosts usually reach the router by its link-local address
+	var routerMAC net.HardwareAddr
+	if routerIP, err := ndp.DefaultRouter(s.ShapeIface); err == nil {
+		for _, neighbor := range neighbors {
+			if neighbor.IP.Equal(routerIP) {
+				routerMAC = neighbor.MAC
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if routerMAC != nil {
+		s.GatewayIPv6 = byMAC[routerMAC.String()]
+		s.SpoofManager.SetRouterIPv6(routerMAC, s.GatewayIPv6)
+	}
+
+	found := 0
+	for _, host := range s.Hosts {
+		if ips := mergeIPs(host.IPv6, byMAC[host.MAC.String()]); !sameIPs(ips, host.IPv6) {
+			s.setIPv6(host, ips)
+		}
+		if len(host.IPv6) > 0 {
+			found++
+		}
+	}
+	return found, nil
+}
+
+// setIPv6 updates a host's IPv6 addresses, extending its limit, priority,
+// connection limit and spoof session to them. Must be called with mu held.
+func (s *Store) setIPv6(host *Host, ips []net.IP) {
+	oldTarget := host.LimitTarget()
+	host.IPv6 = ips
+
+	if host.Limited {
+		s.LimiterFor(host).Remove(oldTarget)
+		if err := s.LimiterFor(host).Apply(host.LimitTarget(), host.Spec); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply limit", err)
+			host.Limited = false
+		}
+	}
+
+	if prioritizer, ok := s.Limiter.(limiter.Prioritizer); ok && host.Priority != "" {
+		if err := prioritizer.Prioritize(host.IP.String(), host.IPv6Strings(), host.Priority); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply priority", err)
+		}
+	}
+
+	if host.ConnLimit > 0 {
+		if err := s.Firewall.LimitConnections(host.IP.String(), host.IPv6Strings(), host.ConnLimit); err != nil {
+			s.publish(events.WorkerError, host, "failed to re-apply connection limit", err)
+		}
+	}
+
+	s.SpoofManager.Retarget(host)
+}
+
+// mergeIPs returns the addresses in either list, keeping the order of known
+func mergeIPs(known, found []net.IP) []net.IP {
+	merged := append([]net.IP(nil), known...)
+	for _, ip := range found {
+		if !containsIP(merged, ip) {
+			merged = append(merged, ip)
+		}
+	}
+	return merged
+}
+
+// sameIPs reports whether a and b hold the same addresses in any order
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, ip := range a {
+		if !containsIP(b, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish reports something that happened to a host
+func (s *Store) publish(kind events.Kind, host *Host, message string, err error) {
+	s.Events.Publish(events.Event{
+		Kind:    kind,
+		Source:  "store",
+		HostID:  host.ID,
+		IP:      host.IP,
+		Message: message,
+		Err:     err,
+	})
 }
 
-// GetHost retrieves a host by IP address string.
-func (s *Store) GetHost(hostId int64) (*Host, bool) {
+// ErrHostNotFound is returned by UpdateHost for unknown host IDs
+var ErrHostNotFound = errors.New("host not found")
+
+// GetHost returns a copy of the host with the given ID. Background workers
+// change hosts at any time, so the copy is only a snapshot; use UpdateHost
+// to act on the host itself.
+func (s *Store) GetHost(hostId int64) (Host, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	host, exists := s.Hosts[hostId]
-	return host, exists
+	if !exists {
+		return Host{}, false
+	}
+	return *host, true
 }
 
-// ListHosts returns all discovered hosts.
-func (s *Store) ListHosts() []*Host {
-	hosts := make([]*Host, 0, len(s.Hosts))
+// ListHosts returns a copy of every known host, sorted by ID.
+func (s *Store) ListHosts() []Host {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hosts := make([]Host, 0, len(s.Hosts))
 	for _, host := range s.Hosts {
-		hosts = append(hosts, host)
+		hosts = append(hosts, *host)
 	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
 	return hosts
 }
 
+// UpdateHost calls fn with the host of the given ID while holding the lock
+// background workers take before changing hosts, so fn sees the host's
+// current addresses and can apply limits for them without racing address
+// changes. It returns fn's error, or ErrHostNotFound. fn must not call other
+// methods of the store that take the lock, such as Redirect.
+func (s *Store) UpdateHost(hostId int64, fn func(host *Host) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.Hosts[hostId]
+	if !exists {
+		return ErrHostNotFound
+	}
+	return fn(host)
+}
+
+// Gateway returns the default gateway's current IP and MAC.
+func (s *Store) Gateway() (net.IP, net.HardwareAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.GatewayIP, s.GatewayMAC
+}
+
 // spoofmanager
 
-// NewSpoofManager returns a new instance of SpoofManager.
-func NewSpoofManager() *SpoofManager {
+// Redirect makes sure a host's traffic passes through this machine on behalf
+// of owner, see SpoofManager.Acquire. In router, bridge and local mode it
+// already does and nothing is spoofed, as for this machine's own traffic.
+// Undo it with SpoofManager.Release, which is a no-op for hosts that were
+// never redirected.
+func (s *Store) Redirect(owner string, host *Host, opts spoof.Options) error {
+	if s.Mode != ModeSpoof || host.Self {
+		return nil
+	}
+	gatewayIP, gatewayMAC := s.Gateway()
+	return s.SpoofManager.Acquire(owner, host, s.Iface, gatewayIP, gatewayMAC, opts)
+}
+
+// Owners of a spoof session, see SpoofManager.Acquire
+const (
+	OwnerSpoof     = "spoof" // explicit 'spoof start', e.g. for monitoring
+	OwnerLimit     = "limit"
+	OwnerPriority  = "prioritize"
+	OwnerConnLimit = "connlimit"
+)
+
+// NewSpoofManager returns a new instance of SpoofManager. The sysctl manager
+// is used to enable forwarding when the first session starts, the firewall to
+// keep the kernel from undoing IPv6 redirection, and session events are
+// published on bus. A non-zero vlanID tags every frame for that VLAN of the
+// interface passed to Acquire.
+func NewSpoofManager(sysctlManager *sysctl.Manager, fw *firewall.Firewall, bus *events.Bus, vlanID uint16, maxPPS, maxFailures int) *SpoofManager {
 	return &SpoofManager{
-		cancelMap: make(map[int64]context.CancelFunc),
+		vlan:        vlanID,
+		sysctl:      sysctlManager,
+		firewall:    fw,
+		events:      bus,
+		maxPPS:      maxPPS,
+		maxFailures: maxFailures,
+		holders:     make(map[int64]map[string]spoof.Options),
+	}
+}
+
+// RestoreTimeout bounds how long Release and Stop wait for ARP caches to be
+// restored.
+const RestoreTimeout = 3 * time.Second
+
+// ReadyTimeout bounds how long Acquire waits for the first poison frames.
+const ReadyTimeout = 3 * time.Second
+
+// Acquire redirects a host on behalf of owner (one of the Owner constants).
+// Several owners can share a session: it covers the union of their
+// directions, refreshes at the shortest interval any of them asked for, and
+// keeps running until the last owner releases it. Acquiring again as the same
+// owner replaces that owner's options.
+//
+// Acquire returns once the first poison frames went out. If they could not be
+// sent, owner's hold is released again and the error returned.
+func (sm *SpoofManager) Acquire(owner string, host *Host, iface *net.Interface, gatewayIP net.IP, gatewayMAC net.HardwareAddr, opts spoof.Options) error {
+	return sm.AcquireMany(owner, []*Host{host}, iface, gatewayIP, gatewayMAC, opts)[host.ID]
+}
+
+// AcquireMany acquires several hosts at once and waits for all of them
+// together. The returned map holds an error for every host that could not be
+// redirected.
+func (sm *SpoofManager) AcquireMany(owner string, hosts []*Host, iface *net.Interface, gatewayIP net.IP, gatewayMAC net.HardwareAddr, opts spoof.Options) map[int64]error {
+	failed := make(map[int64]error)
+	pending := make(map[int64]<-chan error)
+
+	sm.mu.Lock()
+	if sm.engine == nil {
+		sender, err := arp.NewVLANSender(iface, sm.vlan)
+		if err != nil {
+			sm.mu.Unlock()
+			err = fmt.Errorf("failed to open ARP socket on %s: %w", iface.Name, err)
+			for _, host := range hosts {
+				failed[host.ID] = err
+			}
+			return failed
+		}
+		sm.sender = sender
+		sm.engine = spoof.NewEngine(sender, gatewayIP, gatewayMAC, sm.events)
+		sm.engine.SetMaxPPS(sm.maxPPS)
+		sm.engine.SetMaxFailures(sm.maxFailures)
+		if sm.onChange != nil {
+			sm.engine.OnChange(sm.onChange)
+		}
+		engine := sm.engine
+		sm.engine.OnStop(func(hostID int64, reason error) {
+			sm.sessionStopped(engine, hostID)
+		})
+		if len(sm.routerIPs) > 0 {
+			sm.engine.SetRouterIPv6(sm.routerMAC, sm.routerIPs)
+		}
+	}
+
+	// Without forwarding the victim's traffic would be blackholed
+	if len(sm.engine.Sessions()) == 0 && sm.sysctl != nil {
+		if err := sm.sysctl.Enable(); err != nil {
+			log.Printf("Failed to enable forwarding sysctls: %v", err)
+		}
+	}
+	if len(sm.routerIPs) > 0 && sm.firewall != nil {
+		if err := sm.firewall.BlockIPv6Redirects(); err != nil {
+			log.Printf("Failed to block ICMPv6 redirects: %v", err)
+		}
+	}
+
+	for _, host := range hosts {
+		if sm.holders[host.ID] == nil {
+			sm.holders[host.ID] = make(map[string]spoof.Options)
+		}
+		sm.holders[host.ID][owner] = opts
+		merged := sm.merged(host.ID)
+
+		session, exists := sm.engine.Get(host.ID)
+		switch {
+		case !exists:
+			pending[host.ID] = sm.engine.Add(host.ID, host.IP, host.MAC, host.IPv6, merged)
+		case merged.Direction != session.Direction || merged.Interval != session.Interval:
+			pending[host.ID] = sm.engine.Add(host.ID, session.TargetIP, session.TargetMAC, session.TargetIPv6, merged)
+		}
+	}
+	sm.mu.Unlock()
+
+	// Sessions are refreshed concurrently, so one deadline covers them all
+	deadline := time.Now().Add(ReadyTimeout)
+	for id, ready := range pending {
+		if err := waitReady(ready, deadline); err != nil {
+			failed[id] = err
+		}
+	}
+
+	for id := range failed {
+		sm.Release(owner, id)
 	}
+	return failed
 }
 
-// Start begins spoofing the specified host.
-func (sm *SpoofManager) Start(host *Host, iface *net.Interface, gatewayIP net.IP, gatewayMAC net.HardwareAddr) {
+// Release drops owner's hold on a host's session. The session is narrowed to
+// what the remaining owners need, or stopped and restored when none remain.
+// It reports whether the session is still running for other owners.
+func (sm *SpoofManager) Release(owner string, hostID int64) bool {
+	sm.mu.Lock()
+	delete(sm.holders[hostID], owner)
+	if len(sm.holders[hostID]) > 0 {
+		defer sm.mu.Unlock()
+		if session, exists := sm.engine.Get(hostID); exists {
+			merged := sm.merged(hostID)
+			if merged.Direction != session.Direction || merged.Interval != session.Interval {
+				sm.engine.Add(hostID, session.TargetIP, session.TargetMAC, session.TargetIPv6, merged)
+			}
+		}
+		return true
+	}
+	delete(sm.holders, hostID)
+	engine := sm.engine
+	sm.mu.Unlock()
+
+	if engine != nil {
+		waitRestored(engine.Remove(hostID), time.Now().Add(RestoreTimeout))
+	}
+	return false
+}
+
+// sessionStopped forgets the owners of a session engine stopped on its own,
+// so the host counts as no longer redirected and the next Acquire starts a
+// new session.
+func (sm *SpoofManager) sessionStopped(engine *spoof.Engine, hostID int64) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if _, exists := sm.cancelMap[host.ID]; exists {
-		return // already spoofing
+	// Acquired again since, or the engine was replaced
+	if sm.engine != engine || engine.Has(hostID) {
+		return
 	}
+	delete(sm.holders, hostID)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	sm.cancelMap[host.ID] = cancel
+// Holders returns the owners currently holding a host's session, sorted.
+func (sm *SpoofManager) Holders(hostID int64) []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	go spoof.Spoof(ctx, iface, host.IP, host.MAC, gatewayIP, gatewayMAC)
-	time.Sleep(1 * time.Second)
+	owners := make([]string, 0, len(sm.holders[hostID]))
+	for owner := range sm.holders[hostID] {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
 }
 
-// Stop ends spoofing for a specific host.
-func (sm *SpoofManager) Stop(hostID int64) {
+// Restart restores a host's ARP caches and poisons them again at the host's
+// current address, keeping every owner's hold. It reports whether the host
+// had a session to restart.
+func (sm *SpoofManager) Restart(host *Host) bool {
+	sm.mu.Lock()
+	engine := sm.engine
+	sm.mu.Unlock()
+
+	if engine == nil {
+		return false
+	}
+	session, exists := engine.Get(host.ID)
+	if !exists {
+		return false
+	}
+	waitRestored(engine.Remove(host.ID), time.Now().Add(RestoreTimeout))
+
+	sm.mu.Lock()
+	if len(sm.holders[host.ID]) == 0 || sm.engine != engine {
+		sm.mu.Unlock()
+		return false // released or closed while restoring
+	}
+	ready := engine.Add(host.ID, host.IP, host.MAC, host.IPv6, spoof.Options{
+		Direction: session.Direction,
+		Interval:  session.Interval,
+	})
+	sm.mu.Unlock()
+
+	if err := waitReady(ready, time.Now().Add(ReadyTimeout)); err != nil {
+		log.Printf("Failed to re-poison %s: %v", host.IP, err)
+	}
+	return true
+}
+
+// SetRouterIPv6 records the IPv6 router's MAC and addresses. From then on
+// every session poisons neighbor caches for IPv6 alongside ARP.
+func (sm *SpoofManager) SetRouterIPv6(mac net.HardwareAddr, ips []net.IP) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if cancel, exists := sm.cancelMap[hostID]; exists {
-		cancel()
-		delete(sm.cancelMap, hostID)
+	sm.routerMAC, sm.routerIPs = mac, ips
+	if sm.engine == nil {
+		return
+	}
+	if len(ips) > 0 && len(sm.engine.Sessions()) > 0 && sm.firewall != nil {
+		if err := sm.firewall.BlockIPv6Redirects(); err != nil {
+			log.Printf("Failed to block ICMPv6 redirects: %v", err)
+		}
 	}
+	sm.engine.SetRouterIPv6(mac, ips)
 }
 
-// StopAll stops spoofing for all hosts.
-func (sm *SpoofManager) StopAll() {
+// Retarget points a host's session at the host's current addresses without
+// interrupting it. Hosts without a session are left alone.
+func (sm *SpoofManager) Retarget(host *Host) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.engine == nil {
+		return
+	}
+	session, exists := sm.engine.Get(host.ID)
+	if !exists || (session.TargetIP.Equal(host.IP) && bytes.Equal(session.TargetMAC, host.MAC) && sameIPs(session.TargetIPv6, host.IPv6)) {
+		return
+	}
+	sm.engine.Add(host.ID, host.IP, host.MAC, host.IPv6, spoof.Options{
+		Direction: session.Direction,
+		Interval:  session.Interval,
+	})
+}
+
+// merged combines the options of every owner of a host. Must be called with
+// mu held.
+func (sm *SpoofManager) merged(hostID int64) spoof.Options {
+	var merged spoof.Options
+	first := true
+	for _, opts := range sm.holders[hostID] {
+		if first {
+			merged.Direction = opts.Direction
+			first = false
+		} else {
+			merged.Direction = merged.Direction.Union(opts.Direction)
+		}
+		if opts.Interval > 0 && (merged.Interval == 0 || opts.Interval < merged.Interval) {
+			merged.Interval = opts.Interval
+		}
+	}
+	return merged
+}
+
+// IsActive reports whether a host is currently being spoofed.
+func (sm *SpoofManager) IsActive(hostID int64) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.engine != nil && sm.engine.Has(hostID)
+}
+
+// Session returns a snapshot of a host's spoof session.
+func (sm *SpoofManager) Session(hostID int64) (spoof.Session, bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	if sm.engine == nil {
+		return spoof.Session{}, false
+	}
+	return sm.engine.Get(hostID)
+}
+
+// Sessions returns a snapshot of the active spoof sessions.
+func (sm *SpoofManager) Sessions() []spoof.Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.engine == nil {
+		return nil
+	}
+	return sm.engine.Sessions()
+}
+
+// Stop ends spoofing for a specific host regardless of its owners and blocks
+// until the victim's and gateway's ARP caches are restored or RestoreTimeout
+// expires.
+func (sm *SpoofManager) Stop(hostID int64) {
+	sm.mu.Lock()
+	engine := sm.engine
+	delete(sm.holders, hostID)
+	sm.mu.Unlock()
+
+	if engine != nil {
+		waitRestored(engine.Remove(hostID), time.Now().Add(RestoreTimeout))
+	}
+}
+
+// StopAll stops spoofing for all hosts and waits for every session to
+// restore ARP caches.
+func (sm *SpoofManager) StopAll() {
+	sm.mu.Lock()
+	engine := sm.engine
+	sm.holders = make(map[int64]map[string]spoof.Options)
+	sm.mu.Unlock()
+
+	if engine == nil {
+		return
+	}
+
+	var pending []<-chan struct{}
+	for _, session := range engine.Sessions() {
+		pending = append(pending, engine.Remove(session.ID))
+	}
+
+	// Sessions restore concurrently, so one deadline covers them all
+	deadline := time.Now().Add(RestoreTimeout)
+	for _, restored := range pending {
+		waitRestored(restored, deadline)
+	}
+}
+
+// Close stops all sessions, shuts the engine down and releases the shared
+// ARP socket.
+func (sm *SpoofManager) Close() {
+	sm.mu.Lock()
+	engine, sender := sm.engine, sm.sender
+	sm.engine, sm.sender = nil, nil
+	sm.holders = make(map[int64]map[string]spoof.Options)
+	sm.mu.Unlock()
+
+	if engine != nil {
+		waitRestored(engine.Close(), time.Now().Add(RestoreTimeout))
+	}
+	if sender != nil {
+		sender.Close()
+	}
+}
 
-	for _, cancel := range sm.cancelMap {
-		cancel()
+func waitReady(ready <-chan error, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case err := <-ready:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("no ARP frames sent within %s", ReadyTimeout)
 	}
-	sm.cancelMap = make(map[int64]context.CancelFunc)
 }
 
+func waitRestored(done <-chan struct{}, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// DisplaySpoofList prints every spoof session with its statistics and health.
 func (s *Store) DisplaySpoofList() {
-	for id, _ := range s.SpoofManager.cancelMap {
-		host := s.Hosts[id]
-		fmt.Printf("%d\t%s\t%s\n", host.ID, host.IP.String(), host.Hostname)
+	sessions := s.SpoofManager.Sessions()
+	if len(sessions) == 0 {
+		fmt.Println("❌ No active spoofing sessions")
+		return
+	}
+
+	fmt.Println("══════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("%-4s %-15s %-20s %-8s %-6s %-10s %-8s %-8s %-6s %-12s %s\n", "ID", "IP Address", "Hostname", "Dir", "Every", "Uptime", "Sent", "Answered", "Errors", "Health", "Held by")
+	fmt.Println("──────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────")
+
+	var failed []spoof.Session
+	for _, session := range sessions {
+		hostname := "-"
+		if host, exists := s.GetHost(session.ID); exists && host.Hostname != "" {
+			hostname = host.Hostname
+		}
+		interval := session.Interval
+		if interval == 0 {
+			interval = spoof.DefaultInterval
+		}
+		uptime := time.Since(session.StartedAt).Round(time.Second)
+		holders := strings.Join(s.SpoofManager.Holders(session.ID), ",")
+		fmt.Printf("%-4d %-15s %-20s %-8s %-6s %-10s %-8d %-8d %-6d %-12s %s\n", session.ID, session.TargetIP, hostname, session.Direction, interval, uptime, session.PacketsSent, session.Answered, session.SendErrors, healthIndicator(session.Health), holders)
+		if session.LastError != "" {
+			failed = append(failed, session)
+		}
+	}
+
+	fmt.Println("══════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════════")
+	for _, session := range failed {
+		fmt.Printf("⚠️  %s last error: %s\n", session.TargetIP, session.LastError)
+	}
+	fmt.Println()
+}
+
+func healthIndicator(health spoof.Health) string {
+	switch health {
+	case spoof.HealthOK:
+		return "● " + health.String()
+	case spoof.HealthPartial, spoof.HealthNoTraffic:
+		return "◐ " + health.String()
+	case spoof.HealthFailing:
+		return "✖ " + health.String()
+	default:
+		return "? " + health.String()
 	}
 }
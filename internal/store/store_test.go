@@ -0,0 +1,114 @@
+package store
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+var (
+	macA = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	macB = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+	ipA  = net.IPv4(192, 168, 1, 10)
+	ipB  = net.IPv4(192, 168, 1, 20)
+)
+
+// newTestStore returns a store with an in-memory ID registry seeded with
+// saved IDs. Hosts stay unlimited, so no limiter or firewall is needed.
+func newTestStore(t *testing.T, saved map[string]int64) *Store {
+	t.Helper()
+	ids, err := loadIDs("")
+	if err != nil {
+		t.Fatalf("loadIDs() = %v", err)
+	}
+	for mac, id := range saved {
+		ids.byMAC[mac] = id
+		ids.next = max(ids.next, id+1)
+	}
+	return &Store{
+		Hosts:        make(map[int64]*Host),
+		SpoofManager: &SpoofManager{},
+		ids:          ids,
+	}
+}
+
+func TestAddHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		saved map[string]int64 // IDs remembered from an earlier session
+		found []Host           // hosts found by scans, in order
+		want  []Host           // ID, IP, MAC, Hostname and Online of every host
+	}{
+		{
+			name:  "new hosts get fresh IDs",
+			found: []Host{{IP: ipA, MAC: macA}, {IP: ipB, MAC: macB}},
+			want:  []Host{{ID: 1, IP: ipA, MAC: macA, Online: true}, {ID: 2, IP: ipB, MAC: macB, Online: true}},
+		},
+		{
+			name:  "MAC match merges into the existing record",
+			found: []Host{{IP: ipA, MAC: macA}, {IP: ipB, MAC: macA, Hostname: "laptop"}},
+			want:  []Host{{ID: 1, IP: ipB, MAC: macA, Hostname: "laptop", Online: true}},
+		},
+		{
+			name:  "host without a MAC falls back to its IP",
+			found: []Host{{IP: ipA}, {IP: ipA, Hostname: "printer"}},
+			want:  []Host{{ID: 1, IP: ipA, Hostname: "printer", Online: true}},
+		},
+		{
+			name:  "new MAC at a known IP marks the old host offline",
+			found: []Host{{IP: ipA, MAC: macA}, {IP: ipA, MAC: macB}},
+			want:  []Host{{ID: 1, IP: ipA, MAC: macA, Online: false}, {ID: 2, IP: ipA, MAC: macB, Online: true}},
+		},
+		{
+			name:  "rescan of an offline host brings it back",
+			found: []Host{{IP: ipA, MAC: macA}, {IP: ipA, MAC: macB}, {IP: ipB, MAC: macA}},
+			want:  []Host{{ID: 1, IP: ipB, MAC: macA, Online: true}, {ID: 2, IP: ipA, MAC: macB, Online: true}},
+		},
+		{
+			name:  "MAC gets its previous ID",
+			saved: map[string]int64{macB.String(): 7},
+			found: []Host{{IP: ipA, MAC: macA}, {IP: ipB, MAC: macB}},
+			want:  []Host{{ID: 8, IP: ipA, MAC: macA, Online: true}, {ID: 7, IP: ipB, MAC: macB, Online: true}},
+		},
+		{
+			name:  "ID of the host is ignored",
+			found: []Host{{ID: 42, IP: ipA, MAC: macA}},
+			want:  []Host{{ID: 1, IP: ipA, MAC: macA, Online: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tt.saved)
+			for _, found := range tt.found {
+				if _, added := s.AddHost(&found); !added {
+					t.Fatalf("AddHost(%s, %s) added nothing", found.IP, found.MAC)
+				}
+			}
+
+			if len(s.Hosts) != len(tt.want) {
+				t.Fatalf("store has %d hosts, want %d", len(s.Hosts), len(tt.want))
+			}
+			for _, want := range tt.want {
+				got, exists := s.GetHost(want.ID)
+				if !exists {
+					t.Errorf("no host with ID %d", want.ID)
+					continue
+				}
+				if !got.IP.Equal(want.IP) || !bytes.Equal(got.MAC, want.MAC) || got.Hostname != want.Hostname || got.Online != want.Online {
+					t.Errorf("host %d = %s %s %q online=%t, want %s %s %q online=%t", want.ID,
+						got.IP, got.MAC, got.Hostname, got.Online, want.IP, want.MAC, want.Hostname, want.Online)
+				}
+			}
+		})
+	}
+}
+
+func TestAddHostWithoutIP(t *testing.T) {
+	s := newTestStore(t, nil)
+	if _, added := s.AddHost(&Host{MAC: macA}); added {
+		t.Errorf("AddHost() added a host without an IP")
+	}
+	if _, added := s.AddHost(nil); added {
+		t.Errorf("AddHost(nil) added a host")
+	}
+}
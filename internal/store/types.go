@@ -1,38 +1,128 @@
 package store
 
 import (
-	"context"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/prabalesh/slayer/internal/events"
+	"github.com/prabalesh/slayer/internal/firewall"
 	"github.com/prabalesh/slayer/internal/limiter"
+	"github.com/prabalesh/slayer/internal/networking/arp"
+	"github.com/prabalesh/slayer/internal/networking/bridge"
+	"github.com/prabalesh/slayer/internal/networking/sysctl"
+	"github.com/prabalesh/slayer/internal/profile"
+	"github.com/prabalesh/slayer/internal/spoof"
 )
 
+// Config holds startup options for the store.
+type Config struct {
+	Backend      string // Limiter backend: "htb" (default) or "edt"
+	ProfilesPath string // JSON file holding limit profiles
+	HostIDsPath  string // JSON file remembering host IDs by MAC, empty to not persist them
+	Interface    string // Interface to use, detected when empty
+	VLAN         int    // 802.1Q VLAN to work on from a trunk Interface (0 for none)
+	Mode         string // ModeAuto (default), ModeSpoof, ModeRouter, ModeBridge or ModeLocal
+	WANInterface string // Router mode uplink, the default route's interface when empty
+	Bridge       string // Bridge joining Interface and WANInterface in bridge mode
+	SpoofPPS     int    // Global ceiling on ARP frames sent per second
+	Scan         ScanOptions
+	// Consecutive failed refreshes before a spoof session is stopped (0 never)
+	SpoofMaxFailures int
+}
+
+// ScanOptions tunes the ARP sweep of the network. A zero PPS or Timeout
+// selects the scanner's default.
+type ScanOptions struct {
+	PPS     int           // ARP requests sent per second
+	Retries int           // extra rounds for addresses that did not answer
+	Timeout time.Duration // ceiling on a whole scan
+}
+
 // SpoofManager controls spoofing operations per host.
 type SpoofManager struct {
-	cancelMap map[int64]context.CancelFunc
-	mu        sync.Mutex
+	engine      *spoof.Engine // single scheduler for all sessions, started on first Start
+	sender      *arp.Sender   // socket used by the engine
+	vlan        uint16        // tag for frames sent on a trunk
+	sysctl      *sysctl.Manager
+	firewall    *firewall.Firewall
+	events      *events.Bus
+	routerMAC   net.HardwareAddr // IPv6 router, see SetRouterIPv6
+	routerIPs   []net.IP
+	maxPPS      int
+	maxFailures int
+	onChange    func(spoof.Change) // follows addresses the engine retargeted
+	mu          sync.Mutex
+
+	holders map[int64]map[string]spoof.Options // host ID -> owner -> options it needs
 }
 
 // Host represents a discovered device on the network.
 type Host struct {
-	ID            int64            // Unique identifier
-	IP            net.IP           // IPv4 address
-	MAC           net.HardwareAddr // MAC address
-	Hostname      string           // Resolved hostname (if any)
-	Online        bool             // Whether host is currently reachable
-	Limited       bool             // Whether traffic is currently throttled
-	UploadSpeed   string
-	DownloadSpeed string
+	ID        int64             // Stable identifier, assigned by the store per MAC
+	IP        net.IP            // IPv4 address
+	IPv6      []net.IP          // IPv6 addresses seen for the MAC (link-local and global)
+	MAC       net.HardwareAddr  // MAC address
+	Hostname  string            // Resolved hostname (if any)
+	Online    bool              // Whether host is currently reachable
+	Limited   bool              // Whether traffic is currently throttled
+	Spec      limiter.Spec      // Active shaping (rates, ceil, impairments)
+	Profile   string            // Profile the limit came from (if any)
+	Overrides map[string]string // Settings given on top of Profile, kept when it is re-applied
+	Priority  string            // QoS priority tier (empty if not prioritized)
+	ConnLimit int               // Max concurrent connections (0 if unlimited)
+	MatchMAC  bool              // Limit follows the MAC rather than the IP
+	Device    string            // veth or tap of a local container or VM (local mode)
+	Self      bool              // This machine, see SelfID
+	UID       string            // Self only: limit just this user's traffic
+	Cgroup    string            // Self only: limit just this cgroup's traffic
+}
+
+// LimitTarget returns the limiter target describing this host.
+func (h *Host) LimitTarget() limiter.Target {
+	return limiter.Target{
+		IP:       h.IP.String(),
+		MAC:      h.MAC,
+		MatchMAC: h.MatchMAC,
+		Device:   h.Device,
+		UID:      h.UID,
+		Cgroup:   h.Cgroup,
+		IPv6:     h.IPv6Strings(),
+	}
+}
+
+// IPv6Strings returns the host's IPv6 addresses as strings.
+func (h *Host) IPv6Strings() []string {
+	var ips []string
+	for _, ip := range h.IPv6 {
+		ips = append(ips, ip.String())
+	}
+	return ips
 }
 
 // Store holds global network context and all known hosts.
 type Store struct {
-	Iface        *net.Interface   // Active network interface
+	Iface        *net.Interface   // Active network interface, the trunk when VLAN is set, the LAN port of a bridge, nil in local mode
+	ShapeIface   *net.Interface   // Where victims' traffic is forwarded and shaped
+	VLAN         uint16           // VLAN scanned and spoofed on Iface (0 if untagged)
+	Mode         string           // ModeSpoof, ModeRouter, ModeBridge or ModeLocal, never ModeAuto
+	WANIface     *net.Interface   // Router or bridge uplink where upload is shaped, nil otherwise
+	Bridge       *bridge.Bridge   // Bridge in bridge mode, nil otherwise
 	GatewayIP    net.IP           // Default gateway IP
 	GatewayMAC   net.HardwareAddr // Default gateway MAC
+	GatewayIPv6  []net.IP         // IPv6 router addresses, empty without IPv6
 	CIDR         string           // CIDR of the interface (e.g. 192.168.1.0/24)
-	Hosts        map[int64]*Host  // Keyed by IP string (e.g. "192.168.1.101")
+	Hosts        map[int64]*Host  // Keyed by host ID, SelfID for this machine
 	SpoofManager *SpoofManager
-	Limiter      *limiter.Limiter
+	Limiter      limiter.Backend
+	SelfLimiter  *limiter.SelfLimiter // shapes the self host, nil without one
+	Firewall     *firewall.Firewall
+	Profiles     *profile.Manager
+	Sysctl       *sysctl.Manager
+	Events       *events.Bus // notifications from background workers
+	Scan         ScanOptions
+
+	ids *idRegistry // assigns host IDs, guarded by mu
+
+	mu sync.Mutex // guards Hosts and host addresses against background updates
 }